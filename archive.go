@@ -0,0 +1,227 @@
+package sshttp
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	// formatTar and formatZip are the archive formats used to stream a
+	// directory as a tar or zip archive.
+	formatTar = "tar"
+	formatZip = "zip"
+)
+
+// archiveContentTypes maps archive formats to their content types.
+var archiveContentTypes = map[string]string{
+	formatTar: "application/x-tar",
+	formatZip: "application/zip",
+}
+
+// archiveFormat determines which archive format, if any, is requested by r,
+// using either the format query parameter or the Accept header.  If no
+// supported format is requested, an empty string is returned.
+func archiveFormat(r *http.Request) string {
+	if f := r.URL.Query().Get("format"); f != "" {
+		if _, ok := archiveContentTypes[f]; ok {
+			return f
+		}
+
+		return ""
+	}
+
+	for _, a := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(a))
+		if err != nil {
+			continue
+		}
+
+		for f, ct := range archiveContentTypes {
+			if mt == ct {
+				return f
+			}
+		}
+	}
+
+	return ""
+}
+
+// archive streams the contents of the directory dir using the specified
+// archive format, and returns a HTTP response which contains the archive.
+// Because the size of the archive is not known in advance, the response
+// has no Content-Length.  If ctx is canceled, the transfer is aborted.
+func (rt *RoundTripper) archive(ctx context.Context, p *clientPair, dir string, format string) *http.Response {
+	// Name the archive after the directory it contains
+	name := path.Base(dir)
+	if name == "/" || name == "." {
+		name = "archive"
+	}
+
+	h := http.Header{}
+	h.Set("Content-Type", archiveContentTypes[format])
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": name + "." + format,
+	}))
+
+	body := rt.stream(ctx, p, func(ctx context.Context, w io.Writer) error {
+		switch format {
+		case formatTar:
+			return rt.writeTar(ctx, p, dir, w)
+		case formatZip:
+			return rt.writeZip(ctx, p, dir, w)
+		}
+
+		return nil
+	})
+
+	return rt.httpResponse(http.StatusOK, body, h)
+}
+
+// writeTar writes a tar archive of the contents of dir to w.  Files are
+// copied directly from SFTP into the archive, so the directory is never
+// buffered in memory.
+func (rt *RoundTripper) writeTar(ctx context.Context, p *clientPair, dir string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+
+	err := rt.walkArchive(p, dir, func(name string, rel string, fi os.FileInfo) error {
+		// Symbolic links are stored with their target
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			l, err := p.sftpc.ReadLink(name)
+			if err != nil {
+				return err
+			}
+			link = l
+		}
+
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		return rt.copyFile(ctx, p, name, tw, fi.Size())
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// writeZip writes a zip archive of the contents of dir to w.  Each file is
+// compressed as it is read from SFTP, with its size and checksum recorded in
+// a data descriptor following its contents, so the directory is never
+// buffered in memory.
+func (rt *RoundTripper) writeZip(ctx context.Context, p *clientPair, dir string, w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	err := rt.walkArchive(p, dir, func(name string, rel string, fi os.FileInfo) error {
+		// Symbolic links are stored with their target as their contents
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			l, err := p.sftpc.ReadLink(name)
+			if err != nil {
+				return err
+			}
+			link = l
+		}
+
+		hdr, err := zip.FileInfoHeader(fi)
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		if fi.IsDir() {
+			hdr.Name += "/"
+		} else if fi.Mode().IsRegular() {
+			hdr.Method = zip.Deflate
+		}
+
+		zf, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case link != "":
+			_, err := io.WriteString(zf, link)
+			return err
+		case fi.Mode().IsRegular():
+			return rt.copyFile(ctx, p, name, zf, fi.Size())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// walkArchive walks the directory dir, invoking fn with the remote path,
+// slash-separated path relative to dir, and file information for each
+// directory, regular file, and symbolic link which may be served.  Other
+// types of files are skipped.
+func (rt *RoundTripper) walkArchive(p *clientPair, dir string, fn func(name string, rel string, fi os.FileInfo) error) error {
+	walker := p.sftpc.Walk(dir)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return err
+		}
+
+		name, fi := walker.Path(), walker.Stat()
+		rel := strings.TrimPrefix(strings.TrimPrefix(name, dir), "/")
+		if rel == "" {
+			continue
+		}
+
+		// Skip any paths which may not be served, along with their
+		// contents if they are directories
+		if rt.opts.checkPath(name) != nil {
+			if fi.IsDir() {
+				walker.SkipDir()
+			}
+			continue
+		}
+
+		m := fi.Mode()
+		if !m.IsDir() && !m.IsRegular() && m&os.ModeSymlink == 0 {
+			continue
+		}
+
+		if err := fn(name, rel, fi); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyFile opens the remote file name and copies size bytes from it to w.
+func (rt *RoundTripper) copyFile(ctx context.Context, p *clientPair, name string, w io.Writer, size int64) error {
+	f, err := p.sftpc.Open(name)
+	if err != nil {
+		return err
+	}
+
+	return rt.opts.copyN(ctx, w, f, size)
+}
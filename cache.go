@@ -0,0 +1,83 @@
+package sshttp
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A cacheRule applies caching headers to responses for files which match
+// the rule.
+type cacheRule struct {
+	match  func(p string, cType string) bool
+	maxAge time.Duration
+}
+
+// WithCacheControl configures a RoundTripper to send Cache-Control and
+// Expires headers with each successful response, permitting clients and
+// caches to reuse the response for up to maxAge.  By default, no caching
+// headers are sent.
+//
+// WithCacheControl, WithCacheControlByType, and WithCacheControlByPath may
+// be combined; the first matching rule, in the order the options are
+// specified, is applied to each response.
+func WithCacheControl(maxAge time.Duration) Option {
+	return withCacheRule(func(string, string) bool { return true }, maxAge)
+}
+
+// WithCacheControlByType is like WithCacheControl, but only applies to files
+// with the specified media type, such as "text/css".  If contentType ends
+// with a slash, such as "image/", it matches any subtype.
+func WithCacheControlByType(contentType string, maxAge time.Duration) Option {
+	return withCacheRule(func(_ string, cType string) bool {
+		mt, _, err := mime.ParseMediaType(cType)
+		if err != nil {
+			return false
+		}
+		if strings.HasSuffix(contentType, "/") {
+			return strings.HasPrefix(mt, contentType)
+		}
+
+		return mt == contentType
+	}, maxAge)
+}
+
+// WithCacheControlByPath is like WithCacheControl, but only applies to files
+// whose cleaned path matches the path.Match pattern, such as /static/*.js.
+// A malformed pattern never matches.
+func WithCacheControlByPath(pattern string, maxAge time.Duration) Option {
+	return withCacheRule(func(p string, _ string) bool {
+		ok, err := path.Match(pattern, p)
+		return err == nil && ok
+	}, maxAge)
+}
+
+// withCacheRule creates an Option which adds a cacheRule.
+func withCacheRule(match func(p string, cType string) bool, maxAge time.Duration) Option {
+	return func(o *options) {
+		o.cache = append(o.cache, cacheRule{
+			match:  match,
+			maxAge: maxAge,
+		})
+	}
+}
+
+// setCacheHeaders applies Cache-Control and Expires headers to h using the
+// first cache rule which matches the path p and content type cType.  If no
+// rule matches, h is not modified.
+func (o *options) setCacheHeaders(h http.Header, p string, cType string) {
+	p = path.Clean("/" + p)
+
+	for _, rule := range o.cache {
+		if !rule.match(p, cType) {
+			continue
+		}
+
+		h.Set("Cache-Control", "max-age="+strconv.Itoa(int(rule.maxAge/time.Second)))
+		h.Set("Expires", time.Now().Add(rule.maxAge).UTC().Format(http.TimeFormat))
+		return
+	}
+}
@@ -0,0 +1,66 @@
+package sshttp
+
+import (
+	"net/http"
+	"strings"
+)
+
+// WithCORS configures a RoundTripper to permit cross-origin requests from
+// web browsers for the specified origins, such as https://example.com.  CORS
+// headers are added to responses for requests from permitted origins, and
+// preflight OPTIONS requests are answered with the methods and headers which
+// may be used.  The origin "*" permits requests from any origin.
+func WithCORS(origins ...string) Option {
+	return func(o *options) {
+		o.corsOrigins = origins
+	}
+}
+
+// allowOrigin returns the value of the Access-Control-Allow-Origin header
+// for a request from origin, or empty if origin is not permitted.
+func (o *options) allowOrigin(origin string) string {
+	for _, allowed := range o.corsOrigins {
+		switch allowed {
+		case "*":
+			return "*"
+		case origin:
+			return origin
+		}
+	}
+
+	return ""
+}
+
+// setCORSHeaders adds CORS headers to h for a response to r, if r is a
+// cross-origin request from a permitted origin.
+func (o *options) setCORSHeaders(h http.Header, r *http.Request) {
+	if len(o.corsOrigins) == 0 {
+		return
+	}
+
+	// Unless any origin is permitted, the headers depend on the origin of
+	// the request, even if none is specified
+	if o.allowOrigin("") != "*" {
+		addVary(h, "Origin")
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+
+	allowed := o.allowOrigin(origin)
+	if allowed == "" {
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", allowed)
+
+	// Describe permitted requests in response to a preflight request
+	if r.Method != http.MethodOptions {
+		return
+	}
+	h.Set("Access-Control-Allow-Methods", strings.Join(o.methods(), ", "))
+	if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+		h.Set("Access-Control-Allow-Headers", headers)
+	}
+}
@@ -0,0 +1,146 @@
+package sshttp
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"io"
+	"net/http"
+)
+
+const (
+	// defaultEagerDigestSize is the maximum size of a file whose digest
+	// is computed before its response is sent, if none is specified using
+	// WithEagerDigestSize.
+	defaultEagerDigestSize = 1 << 20
+)
+
+// digestAlgorithms maps supported digest algorithm names to their hash
+// constructors.
+var digestAlgorithms = map[string]func() hash.Hash{
+	"md5":     md5.New,
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+// WithDigest configures a RoundTripper to send a digest of each file it
+// serves, so that clients can verify the integrity of the response body.
+// Supported algorithms are "md5", which is sent in the Content-MD5 header,
+// and "sha-256" and "sha-512", which are sent in the Digest header.  An
+// unsupported or empty algorithm disables digests, which is the default.
+//
+// Computing a digest requires reading the entire file.  Files no larger than
+// the size set by WithEagerDigestSize are read twice: once to compute the
+// digest, which is sent as a header, and again to send the response body.
+// Larger files are read only once, with the digest computed as the body is
+// streamed, and sent as a HTTP trailer which is only available to clients
+// after the entire body has been read.  If WithTrailers is set, digests are
+// always sent as trailers.
+func WithDigest(algo string) Option {
+	return func(o *options) {
+		if _, ok := digestAlgorithms[algo]; !ok {
+			algo = ""
+		}
+
+		o.digest = algo
+	}
+}
+
+// WithEagerDigestSize configures the maximum size of a file whose digest is
+// computed before its response is sent, when WithDigest is in use.  If not
+// set, files up to 1 MiB are digested eagerly.  A negative size causes all
+// digests to be sent as trailers.
+func WithEagerDigestSize(size int64) Option {
+	return func(o *options) {
+		o.eagerDigestSize = size
+	}
+}
+
+// digestHeader returns the name of the header or trailer used to send a
+// digest using algo.
+func digestHeader(algo string) string {
+	if algo == "md5" {
+		return "Content-MD5"
+	}
+
+	return "Digest"
+}
+
+// digestValue formats the digest sum computed using algo as a header value.
+func digestValue(algo string, sum []byte) string {
+	v := base64.StdEncoding.EncodeToString(sum)
+	if algo == "md5" {
+		return v
+	}
+
+	return algo + "=" + v
+}
+
+// eagerDigest computes the digest of the first size bytes of f using the
+// configured algorithm.  The file is read using ReadAt, so its offset is not
+// modified, and the entire file can still be transferred.
+func (o *options) eagerDigest(f remoteFile, size int64) (string, error) {
+	h := digestAlgorithms[o.digest]()
+	if _, err := io.CopyN(h, io.NewSectionReader(f, 0, size), size); err != nil {
+		return "", err
+	}
+
+	return digestValue(o.digest, h.Sum(nil)), nil
+}
+
+// useEagerDigest reports whether a digest for a file of the specified size
+// should be computed before its response is sent.
+func (o *options) useEagerDigest(size int64) bool {
+	limit := o.eagerDigestSize
+	if limit == 0 {
+		limit = defaultEagerDigestSize
+	}
+
+	return size <= limit
+}
+
+// WithTrailers configures whether or not a RoundTripper sends metadata about
+// each file transfer in HTTP trailers, which are available to clients once
+// the entire response body has been read.  The X-Transferred-Bytes trailer
+// carries the number of bytes sent in the response body, and any digest
+// configured using WithDigest is computed during the transfer, and sent as a
+// trailer.  Clients can use these trailers to verify that the entire body
+// was received.  By default, no trailers are sent.
+func WithTrailers(enabled bool) Option {
+	return func(o *options) {
+		o.trailers = enabled
+	}
+}
+
+// digestTrailer is an io.Writer which computes a digest of the data written
+// to it, and sets it in a HTTP trailer once complete.
+type digestTrailer struct {
+	algo    string
+	h       hash.Hash
+	trailer http.Header
+}
+
+// newDigestTrailer creates a digestTrailer for the configured algorithm, and
+// declares its trailer in h.
+func (o *options) newDigestTrailer(h http.Header) *digestTrailer {
+	name := digestHeader(o.digest)
+	h.Add("Trailer", name)
+
+	return &digestTrailer{
+		algo:    o.digest,
+		h:       digestAlgorithms[o.digest](),
+		trailer: http.Header{http.CanonicalHeaderKey(name): nil},
+	}
+}
+
+// Write implements io.Writer.
+func (d *digestTrailer) Write(b []byte) (int, error) {
+	return d.h.Write(b)
+}
+
+// finish sets the computed digest in the trailer.
+func (d *digestTrailer) finish() {
+	d.trailer.Set(digestHeader(d.algo), digestValue(d.algo, d.h.Sum(nil)))
+}
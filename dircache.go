@@ -0,0 +1,132 @@
+package sshttp
+
+import (
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// WithDirCacheTTL configures a RoundTripper or FileSystem to cache the
+// contents of remote directories in memory for up to ttl, so that repeated
+// listings of a directory need not read it over SFTP each time.  Each
+// connection maintains its own cache.  A TTL of zero or less disables
+// caching, which is the default.
+func WithDirCacheTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.dirCacheTTL = ttl
+	}
+}
+
+// A dirCache caches the contents of remote directories for a fixed TTL.
+type dirCache struct {
+	ttl time.Duration
+
+	mu sync.Mutex
+	m  map[string]dirCacheEntry
+}
+
+// A dirCacheEntry is a cached directory and its expiration time.
+type dirCacheEntry struct {
+	fis     []os.FileInfo
+	expires time.Time
+}
+
+// newDirCache creates a dirCache using the specified TTL.  If the TTL is
+// zero or less, it returns nil, which disables caching.
+func newDirCache(ttl time.Duration) *dirCache {
+	if ttl <= 0 {
+		return nil
+	}
+
+	return &dirCache{
+		ttl: ttl,
+		m:   make(map[string]dirCacheEntry),
+	}
+}
+
+// get returns a copy of the cached contents of dir, if present and not
+// expired.
+func (c *dirCache) get(dir string) ([]os.FileInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.m[dir]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expires) {
+		delete(c.m, dir)
+		return nil, false
+	}
+
+	return copyFileInfos(e.fis), true
+}
+
+// set caches a copy of the contents of dir, and evicts any expired entries.
+func (c *dirCache) set(dir string, fis []os.FileInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, e := range c.m {
+		if now.After(e.expires) {
+			delete(c.m, k)
+		}
+	}
+
+	c.m[dir] = dirCacheEntry{
+		fis:     copyFileInfos(fis),
+		expires: now.Add(c.ttl),
+	}
+}
+
+// invalidate removes any cached contents of name and of its parent
+// directory, and should be called whenever name is modified.
+func (c *dirCache) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name = path.Clean(name)
+	delete(c.m, name)
+	delete(c.m, path.Dir(name))
+}
+
+// copyFileInfos returns a copy of fis, so that callers may sort or filter
+// it without modifying cached contents.
+func copyFileInfos(fis []os.FileInfo) []os.FileInfo {
+	out := make([]os.FileInfo, len(fis))
+	copy(out, fis)
+	return out
+}
+
+// readDir reads the contents of the remote directory dir, using the
+// connection's directory cache if enabled.
+func (p *clientPair) readDir(dir string) ([]os.FileInfo, error) {
+	if p.dirs == nil {
+		return p.sftpc.ReadDir(dir)
+	}
+
+	if fis, ok := p.dirs.get(dir); ok {
+		return fis, nil
+	}
+
+	fis, err := p.sftpc.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	p.dirs.set(dir, fis)
+
+	return fis, nil
+}
+
+// invalidate removes any cached directory contents or missing path entries
+// affected by modifying name, if the connection's caches are enabled.
+func (p *clientPair) invalidate(name string) {
+	if p.dirs != nil {
+		p.dirs.invalidate(name)
+	}
+	if p.missing != nil {
+		p.missing.remove(path.Clean(name))
+	}
+}
@@ -0,0 +1,70 @@
+package sshttp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// ConfigFromEnv creates a SSH client configuration using environment
+// variables, so that credentials need not be specified in code:
+//   - SSH_USER: the user to authenticate as (required)
+//   - SSH_PASSWORD: a password to authenticate with
+//   - SSH_KEY_FILE: the path to an unencrypted private key to authenticate
+//     with
+//   - SSH_KNOWN_HOSTS: the path to a known_hosts file used to verify host
+//     keys, which defaults to ~/.ssh/known_hosts
+//
+// At least one of SSH_PASSWORD and SSH_KEY_FILE must be set.  If both are
+// set, public key authentication is attempted first.  Host keys are always
+// verified, so a known_hosts file must exist.
+func ConfigFromEnv() (*ssh.ClientConfig, error) {
+	user := os.Getenv("SSH_USER")
+	if user == "" {
+		return nil, errors.New("sshttp: SSH_USER must be set")
+	}
+
+	var auth []ssh.AuthMethod
+	if file := os.Getenv("SSH_KEY_FILE"); file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("sshttp: failed to read SSH_KEY_FILE: %w", err)
+		}
+
+		signer, err := ssh.ParsePrivateKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("sshttp: failed to parse SSH_KEY_FILE: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if password := os.Getenv("SSH_PASSWORD"); password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("sshttp: SSH_PASSWORD or SSH_KEY_FILE must be set")
+	}
+
+	file := os.Getenv("SSH_KNOWN_HOSTS")
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("sshttp: SSH_KNOWN_HOSTS is not set: %w", err)
+		}
+		file = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	hostKey, err := knownhosts.New(file)
+	if err != nil {
+		return nil, fmt.Errorf("sshttp: failed to load known_hosts: %w", err)
+	}
+
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKey,
+	}, nil
+}
@@ -0,0 +1,173 @@
+package sshttp
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// newTestSigner generates a new SSH private key.
+func newTestSigner(t *testing.T) (ssh.Signer, *pem.Block) {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	return signer, block
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, b []byte) string {
+		t.Helper()
+
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(file, b, 0600); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+
+		return file
+	}
+
+	_, key := newTestSigner(t)
+	keyFile := write("id_ed25519", pem.EncodeToMemory(key))
+	badKeyFile := write("id_bad", []byte("not a key"))
+
+	host, _ := newTestSigner(t)
+	other, _ := newTestSigner(t)
+	line := knownhosts.Line([]string{"example.com:22"}, host.PublicKey()) + "\n"
+	knownHosts := write("known_hosts", []byte(line))
+	write("home/.ssh/known_hosts", []byte(line))
+
+	var tests = []struct {
+		desc string
+		env  map[string]string
+		auth int
+		ok   bool
+	}{
+		{
+			desc: "no user",
+			env: map[string]string{
+				"SSH_PASSWORD":    "secret",
+				"SSH_KNOWN_HOSTS": knownHosts,
+			},
+		},
+		{
+			desc: "no credentials",
+			env: map[string]string{
+				"SSH_USER":        "user",
+				"SSH_KNOWN_HOSTS": knownHosts,
+			},
+		},
+		{
+			desc: "missing key file",
+			env: map[string]string{
+				"SSH_USER":        "user",
+				"SSH_KEY_FILE":    filepath.Join(dir, "missing"),
+				"SSH_KNOWN_HOSTS": knownHosts,
+			},
+		},
+		{
+			desc: "invalid key file",
+			env: map[string]string{
+				"SSH_USER":        "user",
+				"SSH_KEY_FILE":    badKeyFile,
+				"SSH_KNOWN_HOSTS": knownHosts,
+			},
+		},
+		{
+			desc: "missing known_hosts",
+			env: map[string]string{
+				"SSH_USER":        "user",
+				"SSH_PASSWORD":    "secret",
+				"SSH_KNOWN_HOSTS": filepath.Join(dir, "missing"),
+			},
+		},
+		{
+			desc: "password",
+			env: map[string]string{
+				"SSH_USER":        "user",
+				"SSH_PASSWORD":    "secret",
+				"SSH_KNOWN_HOSTS": knownHosts,
+			},
+			auth: 1,
+			ok:   true,
+		},
+		{
+			desc: "key and password",
+			env: map[string]string{
+				"SSH_USER":        "user",
+				"SSH_PASSWORD":    "secret",
+				"SSH_KEY_FILE":    keyFile,
+				"SSH_KNOWN_HOSTS": knownHosts,
+			},
+			auth: 2,
+			ok:   true,
+		},
+		{
+			desc: "default known_hosts",
+			env: map[string]string{
+				"SSH_USER":     "user",
+				"SSH_KEY_FILE": keyFile,
+				"HOME":         filepath.Join(dir, "home"),
+			},
+			auth: 1,
+			ok:   true,
+		},
+	}
+
+	for i, tt := range tests {
+		for _, k := range []string{"SSH_USER", "SSH_PASSWORD", "SSH_KEY_FILE", "SSH_KNOWN_HOSTS"} {
+			t.Setenv(k, tt.env[k])
+		}
+		if home, ok := tt.env["HOME"]; ok {
+			t.Setenv("HOME", home)
+		}
+
+		config, err := ConfigFromEnv()
+		if want, got := tt.ok, err == nil; want != got {
+			t.Fatalf("[%02d] test %q, unexpected error: %v", i, tt.desc, err)
+		}
+		if !tt.ok {
+			continue
+		}
+
+		if want, got := "user", config.User; want != got {
+			t.Fatalf("[%02d] test %q, unexpected user: %q != %q",
+				i, tt.desc, want, got)
+		}
+		if want, got := tt.auth, len(config.Auth); want != got {
+			t.Fatalf("[%02d] test %q, unexpected number of auth methods: %v != %v",
+				i, tt.desc, want, got)
+		}
+
+		// Only host keys in known_hosts are accepted
+		addr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+		if err := config.HostKeyCallback("example.com:22", addr, host.PublicKey()); err != nil {
+			t.Fatalf("[%02d] test %q, known host key rejected: %v", i, tt.desc, err)
+		}
+		if err := config.HostKeyCallback("example.com:22", addr, other.PublicKey()); err == nil {
+			t.Fatalf("[%02d] test %q, unknown host key accepted", i, tt.desc)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package sshttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// maxCommandLength is the maximum length of a command read from a
+	// request body by exec.
+	maxCommandLength = 64 << 10
+
+	// exitStatusTrailer is the HTTP trailer which carries a command's
+	// exit status.
+	exitStatusTrailer = "X-Exit-Status"
+)
+
+// WithExec configures a RoundTripper to run commands on remote hosts when a
+// POST request is made to path, such as /.exec.  The command is read from the
+// X-Command header, or from the request body if the header is not set.
+// Commands read from the body which are longer than 64 KiB are refused with
+// 413 Request Entity Too Large.  By default, commands cannot be run.
+//
+// The combined standard output and standard error of the command are
+// streamed as the response body, and the command's exit status is sent in
+// the X-Exit-Status trailer once the body has been read.  If the response
+// body is closed early, the command is killed.
+//
+// Running commands is very powerful, and should only be enabled when all
+// requests made using the RoundTripper are trusted.
+func WithExec(path string) Option {
+	return func(o *options) {
+		o.execPath = path
+	}
+}
+
+// exec runs the command specified by r on the host connected by p, using a
+// new SSH session, and streams its output as a HTTP response.
+func (rt *RoundTripper) exec(p *clientPair, r *http.Request) (*http.Response, error) {
+	cmd := r.Header.Get("X-Command")
+	if cmd == "" && r.Body != nil {
+		// Refuse commands which are too long rather than running a
+		// truncated command
+		b, err := io.ReadAll(io.LimitReader(r.Body, maxCommandLength+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > maxCommandLength {
+			return rt.httpResponse(http.StatusRequestEntityTooLarge, nil, nil), nil
+		}
+		cmd = strings.TrimSpace(string(b))
+	}
+	if cmd == "" {
+		return rt.httpResponse(http.StatusBadRequest, nil, nil), nil
+	}
+
+	sess, err := p.sshc.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	// The trailer is populated once the command exits, before the final
+	// read of the response body returns io.EOF
+	trailer := http.Header{exitStatusTrailer: nil}
+
+	body := rt.stream(r.Context(), p, func(ctx context.Context, w io.Writer) error {
+		defer sess.Close()
+
+		// Kill the command if the request is canceled
+		stop := context.AfterFunc(ctx, func() {
+			_ = sess.Signal(ssh.SIGKILL)
+			_ = sess.Close()
+		})
+		defer stop()
+
+		// Output streams are copied concurrently by the session
+		sw := &syncWriter{w: w}
+		sess.Stdout = sw
+		sess.Stderr = sw
+
+		err := sess.Run(cmd)
+
+		var eerr *ssh.ExitError
+		switch {
+		case err == nil:
+			trailer.Set(exitStatusTrailer, "0")
+		case errors.As(err, &eerr):
+			trailer.Set(exitStatusTrailer, strconv.Itoa(eerr.ExitStatus()))
+			err = nil
+		}
+
+		return err
+	})
+
+	h := http.Header{}
+	h.Set("Trailer", exitStatusTrailer)
+
+	res := rt.httpResponse(http.StatusOK, body, h)
+	res.Trailer = trailer
+	return res, nil
+}
+
+// syncWriter is an io.Writer which serializes writes to its underlying
+// io.Writer, so it can be written to by multiple goroutines.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Write implements io.Writer.
+func (w *syncWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.w.Write(b)
+}
@@ -0,0 +1,42 @@
+package sshttp
+
+// knownExtensions are the names of SFTP protocol extensions which may be
+// reported by ServerExtensions.
+var knownExtensions = []string{
+	"copy-data",
+	"expand-path@openssh.com",
+	"fstatvfs@openssh.com",
+	"fsync@openssh.com",
+	"hardlink@openssh.com",
+	"home-directory",
+	"limits@openssh.com",
+	"lsetstat@openssh.com",
+	"posix-rename@openssh.com",
+	"statvfs@openssh.com",
+	"users-groups-by-id@openssh.com",
+}
+
+// ServerExtensions returns the SFTP protocol extensions advertised by the
+// server on host, mapped to their version data, so that callers can check
+// for support before performing operations which require them, such as
+// hardlink@openssh.com.  The connection to host is dialed or reused from the
+// connection pool in the same manner as RoundTrip.
+//
+// Only well-known extensions, such as those provided by OpenSSH, are
+// reported, because the underlying SFTP client does not expose the names of
+// all extensions advertised by the server.
+func (rt *RoundTripper) ServerExtensions(host string) (map[string]string, error) {
+	p, err := rt.pair(host)
+	if err != nil {
+		return nil, err
+	}
+
+	exts := make(map[string]string)
+	for _, name := range knownExtensions {
+		if data, ok := p.sftpc.HasExtension(name); ok {
+			exts[name] = data
+		}
+	}
+
+	return exts, nil
+}
@@ -1,13 +1,18 @@
 package sshttp
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
 
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
@@ -15,66 +20,120 @@ import (
 
 // File implements http.File using remote files over SFTP, and is returned
 // by FileSystem's Open method.
+//
+// Because the embedded sftp.File implements io.Seeker and io.ReaderAt,
+// http.ServeContent can satisfy range requests for a File by seeking
+// directly to the requested offset, without reading from the start.
+//
+// Like os.File, a File's Read and Seek methods share a single offset, so a
+// File should not be read by multiple goroutines at once.  Readdir is safe
+// for concurrent use.
 type File struct {
 	// Embed for interface implementation
 	*sftp.File
 
-	// Client for use with File.Readdir
-	sftpc *sftp.Client
+	// Connection for use with File.Readdir
+	pair *clientPair
 
 	// Name of file in remote filesystem
 	name string
 
-	// Current file offset with File.Readdir
-	offset int
+	// Name of the directory read by File.Readdir, relative to the
+	// FileSystem's directory, and the options used to filter its entries
+	dir  string
+	opts *options
+
+	// Directory entries read by File.Readdir, cached after the first
+	// call so that large directories are only read once
+	mu      sync.Mutex
+	entries []os.FileInfo
+	cached  bool
 
-	// EOF on next Readdir loop
-	eofNext bool
+	// Current entry offset with File.Readdir
+	offset int
 }
 
 // Readdir is used to implement http.File for remote files over SFTP.
 // It behaves in the same manner as os.File.Readdir:
 // https://godoc.org/os#File.Readdir.
 func (f *File) Readdir(count int) ([]os.FileInfo, error) {
-	// Return and signal end of files
-	if f.eofNext {
-		return nil, io.EOF
-	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-	// Gather other files in the same directory
-	fis, err := f.sftpc.ReadDir(filepath.Dir(f.name))
-	if err != nil {
-		return nil, err
+	// Gather other files in the same directory, only once
+	if !f.cached {
+		fis, err := f.pair.readDir(path.Dir(f.name))
+		if err != nil {
+			return nil, err
+		}
+		sort.Sort(byBaseName(fis))
+
+		// Omit any entries which may not be served
+		out := fis[:0]
+		for _, fi := range fis {
+			if f.opts != nil && f.opts.checkPath(path.Join(f.dir, fi.Name())) != nil {
+				continue
+			}
+			out = append(out, fi)
+		}
+
+		f.entries = out
+		f.cached = true
 	}
-	sort.Sort(byBaseName(fis))
+	rest := f.entries[f.offset:]
 
-	// If 0 or negative count is specified, return all files
-	// and EOF next.
-	if count <= 0 || len(fis) <= count {
-		f.eofNext = true
-		return fis, nil
+	// If 0 or negative count is specified, return all remaining files
+	if count <= 0 {
+		f.offset = len(f.entries)
+		return rest, nil
 	}
 
-	// If files with offset is less than requested length,
-	// return the remainder and EOF next.
-	if len(fis)-f.offset <= count {
-		f.eofNext = true
-		return fis[f.offset:], nil
+	// Signal end of files
+	if len(rest) == 0 {
+		return nil, io.EOF
 	}
 
-	// If more files exist than requested, return requested
-	// number and add to offset
+	// Return up to the requested number of files and add to offset
+	if count > len(rest) {
+		count = len(rest)
+	}
 	out := make([]os.FileInfo, count)
-	copy(out, fis[f.offset:f.offset+count])
+	copy(out, rest[:count])
 	f.offset += count
 
 	return out, nil
 }
 
+// Readdirnames is like Readdir, but returns only the names of files in the
+// directory.  It behaves in the same manner as os.File.Readdirnames:
+// https://godoc.org/os#File.Readdirnames.
+func (f *File) Readdirnames(n int) ([]string, error) {
+	fis, err := f.Readdir(n)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+
+	return names, nil
+}
+
 // FileSystem implements http.FileSystem for remote files over SFTP.
+//
+// A FileSystem is safe for concurrent use, such as by a http.FileServer
+// serving many requests at once.  All requests share a single SFTP client,
+// which multiplexes concurrent operations over one SSH connection, and each
+// File returned by Open has its own independent state.
 type FileSystem struct {
 	pair *clientPair
 	path string
+	opts options
+
+	// Fully resolved root directory, used to confine symbolic links
+	root string
 }
 
 // NewFileSystem creates a new FileSystem which can access remote files over
@@ -85,8 +144,13 @@ type FileSystem struct {
 //
 // A host must be a complete URI, including a protocol segment.  For example,
 // sftp://127.0.0.1:22/home/foo dials 127.0.0.1 on port 22, and accesses the
-// /home/foo directory on the host.
-func NewFileSystem(host string, config *ssh.ClientConfig) (*FileSystem, error) {
+// /home/foo directory on the host.  The directory is always interpreted as
+// an absolute path, and if none is specified, such as for
+// sftp://127.0.0.1:22, the root directory of the host is accessed.
+//
+// Zero or more Option values may be specified to further configure the
+// FileSystem.
+func NewFileSystem(host string, config *ssh.ClientConfig, opts ...Option) (*FileSystem, error) {
 	// Ensure valid URI with proper protocol
 	u, err := url.Parse(host)
 	if err != nil {
@@ -95,57 +159,483 @@ func NewFileSystem(host string, config *ssh.ClientConfig) (*FileSystem, error) {
 	if u.Scheme != Protocol {
 		return nil, fmt.Errorf("invalid URL scheme: %s", u.Scheme)
 	}
+	if u.Host == "" || u.Opaque != "" {
+		return nil, fmt.Errorf("invalid URL: %s: host must be specified", host)
+	}
+
+	// Normalize the root directory, which defaults to the remote root
+	// directory instead of the remote user's working directory
+	o := newOptions(opts)
+	root := o.cleanPath(u.Path)
 
 	// Create clientPair with SSH and SFTP clients
-	pair, err := dialSSHSFTP(u.Host, config)
+	pair, err := dialSSHSFTP(u.Host, config, &o)
 	if err != nil {
 		return nil, err
 	}
 
-	return &FileSystem{
+	fs := &FileSystem{
 		pair: pair,
-		path: u.Path,
-	}, nil
+		path: root,
+		opts: o,
+	}
+
+	// Resolve the root directory once if symbolic links are followed
+	if o.symlinkHops > 0 {
+		root, err := pair.sftpc.RealPath(fs.join("/"))
+		if err != nil {
+			_ = pair.close()
+			return nil, err
+		}
+		fs.root = root
+	}
+
+	return fs, nil
 }
 
 // Open attempts to access a file under the directory specified in NewFileSystem,
 // and attempts to return a http.File for use with net/http.
+//
+// Errors reported by the remote host for missing or inaccessible files
+// satisfy os.IsNotExist and os.IsPermission respectively, so that
+// http.FileServer responds with 404 and 403 for them.
 func (fs *FileSystem) Open(name string) (http.File, error) {
+	// Ensure this path may be served
+	if err := fs.opts.checkPath(name); err != nil {
+		return nil, err
+	}
+
 	// Check for the requested file in the remote filesystem
-	fpath := filepath.Join(fs.path, name)
-	f, err := fs.pair.sftpc.Open(fpath)
+	fpath, err := fs.target(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the file before opening it, so that no handle is opened for
+	// files which are too large to be served
+	stat, err := fs.stat(fpath)
 	if err != nil {
 		return nil, err
 	}
+	if limit := fs.opts.maxSize; limit > 0 && !stat.IsDir() && stat.Size() > limit {
+		return nil, ErrFileTooLarge
+	}
+
+	var f *sftp.File
+	err = fs.opts.retry(context.Background(), func() (err error) {
+		f, err = fs.pair.sftpc.Open(fpath)
+		return err
+	})
+	if err != nil {
+		return nil, fsError(err)
+	}
 
 	// Create output file
 	file := &File{
 		File: f,
 
-		sftpc: fs.pair.sftpc,
-		name:  fs.path,
+		pair: fs.pair,
+		name: fpath,
+		dir:  path.Dir(fs.opts.cleanPath(name)),
+		opts: &fs.opts,
 	}
 
-	// Check for a directory instead of a file, which requires
-	// a slightly different name with a trailing slash
-	stat, err := f.Stat()
-	if err != nil {
-		return nil, err
-	}
+	// Directories require a slightly different name with a trailing slash
 	if stat.IsDir() {
 		file.name = fpath + "/"
+		file.dir = name
 	}
 
 	return file, nil
 }
 
+// IsDir reports whether the named file under the directory specified in
+// NewFileSystem is a directory.  Like Stat, IsDir does not open the file, so
+// it is cheaper than calling Open and then Stat.  If the file does not exist,
+// an error satisfying os.IsNotExist is returned.
+func (fs *FileSystem) IsDir(name string) (bool, error) {
+	fi, err := fs.Stat(name)
+	if err != nil {
+		return false, err
+	}
+
+	return fi.IsDir(), nil
+}
+
+// Stat returns an os.FileInfo describing the named file under the directory
+// specified in NewFileSystem, without opening the file.  If the file does
+// not exist, an error satisfying os.IsNotExist is returned.
+func (fs *FileSystem) Stat(name string) (os.FileInfo, error) {
+	if err := fs.opts.checkPath(name); err != nil {
+		return nil, err
+	}
+
+	fpath, err := fs.target(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.stat(fpath)
+}
+
+// stat retrieves information about the remote file at the resolved path
+// fpath, following any symbolic links.
+func (fs *FileSystem) stat(fpath string) (os.FileInfo, error) {
+	var fi os.FileInfo
+	err := fs.opts.retry(context.Background(), func() (err error) {
+		fi, err = fs.pair.sftpc.Stat(fpath)
+		return err
+	})
+	if err != nil {
+		return nil, fsError(err)
+	}
+
+	return fi, nil
+}
+
+// Lstat is like Stat, but if the named file is a symbolic link, the
+// returned os.FileInfo describes the link itself instead of its target.
+func (fs *FileSystem) Lstat(name string) (os.FileInfo, error) {
+	if err := fs.opts.checkPath(name); err != nil {
+		return nil, err
+	}
+
+	fpath, err := fs.entry(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var fi os.FileInfo
+	err = fs.opts.retry(context.Background(), func() (err error) {
+		fi, err = fs.pair.sftpc.Lstat(fpath)
+		return err
+	})
+	if err != nil {
+		return nil, fsError(err)
+	}
+
+	return fi, nil
+}
+
+// ReadFile reads the entire contents of the named file under the directory
+// specified in NewFileSystem.  If the file does not exist, an error
+// satisfying os.IsNotExist is returned.
+func (fs *FileSystem) ReadFile(name string) ([]byte, error) {
+	if err := fs.opts.checkPath(name); err != nil {
+		return nil, err
+	}
+
+	fpath, err := fs.target(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var f *sftp.File
+	err = fs.opts.retry(context.Background(), func() (err error) {
+		f, err = fs.pair.sftpc.Open(fpath)
+		return err
+	})
+	if err != nil {
+		return nil, fsError(err)
+	}
+	defer f.Close()
+
+	// Use the file's size as a hint to avoid growing the buffer
+	var size int64
+	if fi, err := f.Stat(); err == nil {
+		size = fi.Size()
+	}
+	if limit := fs.opts.maxSize; limit > 0 && size > limit {
+		return nil, ErrFileTooLarge
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, size+bytes.MinRead))
+	if _, err := buf.ReadFrom(f); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Rename renames (moves) oldName to newName under the directory specified
+// in NewFileSystem.  Both names must be paths which may be served by this
+// FileSystem.  If oldName does not exist, an error satisfying os.IsNotExist
+// is returned.
+//
+// The directory specified in NewFileSystem may neither be renamed nor
+// replaced, and attempting to do so returns an error satisfying
+// errors.Is(err, os.ErrInvalid).
+func (fs *FileSystem) Rename(oldName string, newName string) error {
+	for _, name := range []string{oldName, newName} {
+		if fs.opts.cleanPath(name) == "/" {
+			return &os.LinkError{Op: "Rename", Old: oldName, New: newName, Err: os.ErrInvalid}
+		}
+		if err := fs.opts.checkPath(name); err != nil {
+			return err
+		}
+	}
+
+	oldPath, err := fs.entry(oldName)
+	if err != nil {
+		return err
+	}
+	newPath, err := fs.entry(newName)
+	if err != nil {
+		return err
+	}
+	if err := fs.pair.sftpc.Rename(oldPath, newPath); err != nil {
+		return fsError(err)
+	}
+
+	fs.pair.invalidate(oldPath)
+	fs.pair.invalidate(newPath)
+
+	return nil
+}
+
+// Remove removes the named file or empty directory under the directory
+// specified in NewFileSystem.  If the file does not exist, an error
+// satisfying os.IsNotExist is returned.  Like RemoveAll, Remove refuses to
+// remove the directory specified in NewFileSystem itself.
+func (fs *FileSystem) Remove(name string) error {
+	if fs.opts.cleanPath(name) == "/" {
+		return &os.PathError{Op: "Remove", Path: name, Err: os.ErrInvalid}
+	}
+	if err := fs.opts.checkPath(name); err != nil {
+		return err
+	}
+
+	fpath, err := fs.entry(name)
+	if err != nil {
+		return err
+	}
+	if err := fs.pair.sftpc.Remove(fpath); err != nil {
+		return fsError(err)
+	}
+	fs.pair.invalidate(fpath)
+
+	return nil
+}
+
+// RemoveAll removes the named file or directory under the directory
+// specified in NewFileSystem, along with any children it contains.  It
+// behaves in the same manner as os.RemoveAll: if the file does not exist,
+// RemoveAll returns nil.  Symbolic links are removed, but never followed.
+// Any children which may not be served by this FileSystem are not removed,
+// so a directory containing them cannot be removed either.
+//
+// Like os.RemoveAll, RemoveAll refuses to remove the directory specified in
+// NewFileSystem itself, such as for the names "/", ".", or "..", and returns
+// an error satisfying errors.Is(err, os.ErrInvalid).
+func (fs *FileSystem) RemoveAll(name string) error {
+	if name == "" {
+		return nil
+	}
+	if fs.opts.cleanPath(name) == "/" {
+		return &os.PathError{Op: "RemoveAll", Path: name, Err: os.ErrInvalid}
+	}
+	if err := fs.opts.checkPath(name); err != nil {
+		if err == os.ErrNotExist {
+			return nil
+		}
+
+		return err
+	}
+
+	fpath, err := fs.entry(name)
+	if err == nil {
+		err = fs.removeAll(name, fpath)
+	}
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// removeAll recursively removes name, located at the remote path fpath,
+// and its children.
+func (fs *FileSystem) removeAll(name string, fpath string) error {
+	fi, err := fs.pair.sftpc.Lstat(fpath)
+	if err != nil {
+		return fsError(err)
+	}
+	defer fs.pair.invalidate(fpath)
+
+	if !fi.IsDir() {
+		return fsError(fs.pair.sftpc.Remove(fpath))
+	}
+
+	// Read the directory directly, as cached contents may be stale
+	fis, err := fs.pair.sftpc.ReadDir(fpath)
+	if err != nil {
+		return fsError(err)
+	}
+	for _, fi := range fis {
+		child := path.Join(name, fi.Name())
+		if fs.opts.checkPath(child) != nil {
+			continue
+		}
+
+		if err := fs.removeAll(child, path.Join(fpath, fi.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return fsError(fs.pair.sftpc.RemoveDirectory(fpath))
+}
+
+// Walk walks the file tree rooted at root under the directory specified in
+// NewFileSystem, calling fn for each file or directory in the tree, including
+// root.  It behaves in the same manner as filepath.Walk:
+// https://godoc.org/path/filepath#Walk.
+//
+// Paths passed to fn are relative to the directory specified in
+// NewFileSystem.  Symbolic links are never followed, so symbolic link loops
+// cannot cause Walk to recurse infinitely.  Any paths which may not be
+// served by this FileSystem are skipped.
+func (fs *FileSystem) Walk(root string, fn filepath.WalkFunc) error {
+	info, err := fs.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = fs.walk(root, info, fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+
+	return err
+}
+
+// walk recursively descends name, calling fn for each entry.
+func (fs *FileSystem) walk(name string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(name, info, nil)
+	}
+
+	// Report the directory itself before its contents, along with any
+	// error which occurred while reading it
+	fis, err := fs.pair.readDir(fs.join(name))
+	if err != nil {
+		err = fsError(err)
+	}
+	if ferr := fn(name, info, err); err != nil || ferr != nil {
+		return ferr
+	}
+	sort.Sort(byBaseName(fis))
+
+	for _, fi := range fis {
+		child := path.Join(name, fi.Name())
+		if fs.opts.checkPath(child) != nil {
+			continue
+		}
+
+		if err := fs.walk(child, fi, fn); err != nil {
+			if !fi.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Glob returns the names of all files under the directory specified in
+// NewFileSystem which match pattern.  It behaves in the same manner as
+// filepath.Glob: https://godoc.org/path/filepath#Glob, but patterns always
+// use forward slashes and are matched using path.Match, regardless of the
+// local operating system.
+//
+// Patterns are interpreted relative to the directory specified in
+// NewFileSystem, so "logs/*.gz" matches compressed files in its logs
+// subdirectory, and returned names are relative to it as well.  Any paths
+// which may not be served by this FileSystem are never matched.  As with
+// filepath.Glob, I/O errors such as unreadable directories are ignored, and
+// the only possible error is path.ErrBadPattern.
+func (fs *FileSystem) Glob(pattern string) ([]string, error) {
+	// Check the pattern's syntax up front, as filepath.Glob does
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	if pattern == "" {
+		return nil, nil
+	}
+
+	rooted := strings.HasPrefix(pattern, "/")
+	elems := strings.Split(strings.Trim(path.Clean("/"+pattern), "/"), "/")
+
+	// Expand each element of the pattern in turn, starting at the root
+	matches := []string{"/"}
+	for _, elem := range elems {
+		if elem == "" {
+			break
+		}
+
+		var next []string
+		for _, dir := range matches {
+			// Literal elements need not be listed; their existence is
+			// checked later
+			if !hasMeta(elem) {
+				next = append(next, path.Join(dir, elem))
+				continue
+			}
+
+			fis, err := fs.pair.readDir(fs.join(dir))
+			if err != nil {
+				continue
+			}
+			sort.Sort(byBaseName(fis))
+
+			for _, fi := range fis {
+				if ok, _ := path.Match(elem, fi.Name()); ok {
+					next = append(next, path.Join(dir, fi.Name()))
+				}
+			}
+		}
+
+		matches = next
+	}
+
+	// Matches for a literal final element may not exist
+	literal := !hasMeta(elems[len(elems)-1])
+
+	var out []string
+	for _, name := range matches {
+		if fs.opts.checkPath(name) != nil {
+			continue
+		}
+		if literal {
+			if _, err := fs.pair.sftpc.Lstat(fs.join(name)); err != nil {
+				continue
+			}
+		}
+
+		if !rooted {
+			name = strings.TrimPrefix(name, "/")
+		}
+		out = append(out, name)
+	}
+
+	return out, nil
+}
+
+// hasMeta reports whether elem contains any special characters recognized
+// by path.Match.
+func hasMeta(elem string) bool {
+	return strings.ContainsAny(elem, "*?[\\")
+}
+
 // Close closes open SFTP and SSH connections for this FileSystem.
 func (fs *FileSystem) Close() error {
-	var sErr stickyError
-	sErr.Set(fs.pair.sftpc.Close())
-	sErr.Set(fs.pair.sshc.Close())
+	return fs.pair.close()
+}
 
-	return sErr.Get()
+// join cleans name as if it were rooted, and joins it with the directory
+// specified in NewFileSystem.  Because name is cleaned before being joined,
+// elements such as ".." can never be used to escape the directory.
+func (fs *FileSystem) join(name string) string {
+	return path.Join(fs.path, fs.opts.cleanPath(name))
 }
 
 // byBaseName implements sort.Interface to sort []os.FileInfo.
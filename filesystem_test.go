@@ -0,0 +1,617 @@
+package sshttp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// newTestFileSystem creates a FileSystem for the directory dir on s, which is
+// closed when the test ends.
+func newTestFileSystem(t *testing.T, s *testServer, dir string, opts ...Option) *FileSystem {
+	t.Helper()
+
+	fs, err := NewFileSystem(Protocol+"://"+s.addr+dir, testClientConfig(), opts...)
+	if err != nil {
+		t.Fatalf("failed to create FileSystem: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = fs.Close()
+	})
+
+	return fs
+}
+
+func TestNewFileSystemInvalidURL(t *testing.T) {
+	var tests = []struct {
+		desc string
+		host string
+	}{
+		{
+			desc: "no scheme",
+			host: "127.0.0.1:22/home/foo",
+		},
+		{
+			desc: "wrong scheme",
+			host: "http://127.0.0.1:22/home/foo",
+		},
+		{
+			desc: "no host",
+			host: "sftp:///home/foo",
+		},
+		{
+			desc: "opaque",
+			host: "sftp:home/foo",
+		},
+	}
+
+	for i, tt := range tests {
+		if _, err := NewFileSystem(tt.host, testClientConfig()); err == nil {
+			t.Fatalf("[%02d] test %q, expected an error", i, tt.desc)
+		}
+	}
+}
+
+func TestNewFileSystemRoot(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "foo", []byte("foo"))
+
+	var tests = []struct {
+		desc string
+		dir  string
+		want string
+	}{
+		{
+			desc: "empty",
+			dir:  "",
+			want: "/",
+		},
+		{
+			desc: "slash",
+			dir:  "/",
+			want: "/",
+		},
+		{
+			desc: "clean",
+			dir:  s.root,
+			want: s.root,
+		},
+		{
+			desc: "trailing slash",
+			dir:  s.root + "/",
+			want: s.root,
+		},
+		{
+			desc: "relative elements",
+			dir:  s.root + "/./bar/..",
+			want: s.root,
+		},
+	}
+
+	for i, tt := range tests {
+		fs := newTestFileSystem(t, s, tt.dir)
+		if want, got := tt.want, fs.path; want != got {
+			t.Fatalf("[%02d] test %q, unexpected root: %q != %q",
+				i, tt.desc, want, got)
+		}
+
+		name := "foo"
+		if tt.want == "/" {
+			name = s.path("foo")
+		}
+
+		b, err := fs.ReadFile(name)
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to read file: %v",
+				i, tt.desc, err)
+		}
+		if want, got := "foo", string(b); want != got {
+			t.Fatalf("[%02d] test %q, unexpected contents: %q != %q",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestFileSystemFileServerStatus(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "public.txt", []byte("hello"))
+	s.writeFile(t, "secret/key", []byte("secret"))
+	s.writeFile(t, ".hidden", []byte("hidden"))
+
+	fs := newTestFileSystem(t, s, s.root,
+		WithDenyDotfiles(true),
+		WithDenyGlobs("/secret/*"),
+	)
+	srv := httptest.NewServer(http.FileServer(fs))
+	defer srv.Close()
+
+	var tests = []struct {
+		path string
+		code int
+	}{
+		{path: "/public.txt", code: http.StatusOK},
+		{path: "/missing.txt", code: http.StatusNotFound},
+		{path: "/.hidden", code: http.StatusNotFound},
+		{path: "/secret/key", code: http.StatusForbidden},
+	}
+
+	for i, tt := range tests {
+		res, err := http.Get(srv.URL + tt.path)
+		if err != nil {
+			t.Fatalf("[%02d] path %q, failed to perform request: %v",
+				i, tt.path, err)
+		}
+		_ = res.Body.Close()
+
+		if want, got := tt.code, res.StatusCode; want != got {
+			t.Fatalf("[%02d] path %q, unexpected status code: %v != %v",
+				i, tt.path, want, got)
+		}
+	}
+}
+
+func TestFileSystemFileServerRanges(t *testing.T) {
+	file := testFile(64 * 1024)
+
+	s := newTestServer(t)
+	s.writeFile(t, "file.bin", file)
+
+	fs := newTestFileSystem(t, s, s.root)
+	srv := httptest.NewServer(http.FileServer(fs))
+	defer srv.Close()
+
+	var tests = []struct {
+		header string
+		cRange string
+		body   []byte
+	}{
+		{
+			header: "bytes=0-9",
+			cRange: "bytes 0-9/65536",
+			body:   file[:10],
+		},
+		{
+			header: "bytes=60000-",
+			cRange: "bytes 60000-65535/65536",
+			body:   file[60000:],
+		},
+		{
+			header: "bytes=-100",
+			cRange: "bytes 65436-65535/65536",
+			body:   file[65436:],
+		},
+	}
+
+	for i, tt := range tests {
+		r, err := http.NewRequest(http.MethodGet, srv.URL+"/file.bin", nil)
+		if err != nil {
+			t.Fatalf("[%02d] failed to create request: %v", i, err)
+		}
+		r.Header.Set("Range", tt.header)
+
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("[%02d] range %q, failed to perform request: %v",
+				i, tt.header, err)
+		}
+		body, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatalf("[%02d] range %q, failed to read body: %v",
+				i, tt.header, err)
+		}
+
+		if want, got := http.StatusPartialContent, res.StatusCode; want != got {
+			t.Fatalf("[%02d] range %q, unexpected status code: %v != %v",
+				i, tt.header, want, got)
+		}
+		if want, got := tt.cRange, res.Header.Get("Content-Range"); want != got {
+			t.Fatalf("[%02d] range %q, unexpected Content-Range: %q != %q",
+				i, tt.header, want, got)
+		}
+		if !bytes.Equal(tt.body, body) {
+			t.Fatalf("[%02d] range %q, unexpected body", i, tt.header)
+		}
+	}
+}
+
+func TestFileSystemFileServerConcurrent(t *testing.T) {
+	const files = 8
+
+	s := newTestServer(t)
+	for i := 0; i < files; i++ {
+		s.writeFile(t, fmt.Sprintf("dir/%d.txt", i), testFile(1024*(i+1)))
+	}
+
+	fs := newTestFileSystem(t, s, s.root)
+	srv := httptest.NewServer(http.FileServer(fs))
+	defer srv.Close()
+
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, 4*files)
+	)
+	for i := 0; i < 4*files; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// Interleave directory listings with file downloads
+			u, want := srv.URL+"/dir/", []byte("7.txt")
+			if i%2 == 0 {
+				n := i / 2 % files
+				u, want = fmt.Sprintf("%s/dir/%d.txt", srv.URL, n), testFile(1024*(n+1))
+			}
+
+			res, err := http.Get(u)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer res.Body.Close()
+
+			b, err := io.ReadAll(res.Body)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			if res.StatusCode != http.StatusOK || !bytes.Contains(b, want) {
+				errs <- fmt.Errorf("unexpected response for %s: %s", u, res.Status)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestFileSystemRemove(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "file", nil)
+	s.writeFile(t, "dir/file", nil)
+	if err := os.Mkdir(filepath.FromSlash(s.path("empty")), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	fs := newTestFileSystem(t, s, s.root)
+
+	var tests = []struct {
+		name string
+		ok   bool
+		err  error
+	}{
+		{name: "file", ok: true},
+		{name: "empty", ok: true},
+		{name: "missing", err: os.ErrNotExist},
+		{name: "dir"},
+	}
+
+	for i, tt := range tests {
+		err := fs.Remove(tt.name)
+		if tt.ok != (err == nil) {
+			t.Fatalf("[%02d] name %q, unexpected error: %v", i, tt.name, err)
+		}
+		if tt.err != nil && !errors.Is(err, tt.err) {
+			t.Fatalf("[%02d] name %q, unexpected error: %v != %v",
+				i, tt.name, tt.err, err)
+		}
+		if tt.err != nil {
+			continue
+		}
+
+		if _, err := os.Lstat(filepath.FromSlash(s.path(tt.name))); tt.ok != os.IsNotExist(err) {
+			t.Fatalf("[%02d] name %q, unexpected file state after Remove: %v",
+				i, tt.name, err)
+		}
+	}
+}
+
+func TestFileSystemRemoveAll(t *testing.T) {
+	s := newTestServer(t)
+	for _, name := range []string{
+		"tree/a",
+		"tree/b/c",
+		"tree/b/d/e",
+		"tree/f/g",
+		"keep/.hidden",
+	} {
+		s.writeFile(t, name, []byte(name))
+	}
+	if err := os.Symlink(filepath.FromSlash(s.path("keep")), filepath.FromSlash(s.path("tree/link"))); err != nil {
+		t.Fatalf("failed to create symbolic link: %v", err)
+	}
+
+	fs := newTestFileSystem(t, s, s.root, WithDenyDotfiles(true))
+
+	if err := fs.RemoveAll("tree"); err != nil {
+		t.Fatalf("failed to remove tree: %v", err)
+	}
+	if _, err := os.Lstat(filepath.FromSlash(s.path("tree"))); !os.IsNotExist(err) {
+		t.Fatalf("tree was not removed: %v", err)
+	}
+
+	// Symbolic links are removed without following them
+	if _, err := os.Stat(filepath.FromSlash(s.path("keep/.hidden"))); err != nil {
+		t.Fatalf("symbolic link target was removed: %v", err)
+	}
+
+	// Missing files are not an error, but the root and directories with
+	// children which may not be served cannot be removed
+	if err := fs.RemoveAll("missing"); err != nil {
+		t.Fatalf("unexpected error for missing file: %v", err)
+	}
+	for _, name := range []string{"/", ".", "..", "foo/.."} {
+		if err := fs.RemoveAll(name); !errors.Is(err, os.ErrInvalid) {
+			t.Fatalf("unexpected error for root %q: %v", name, err)
+		}
+	}
+	if err := fs.RemoveAll("keep"); err == nil {
+		t.Fatal("expected an error for directory with hidden children")
+	}
+	if _, err := os.Stat(filepath.FromSlash(s.path("keep/.hidden"))); err != nil {
+		t.Fatalf("hidden file was removed: %v", err)
+	}
+}
+
+func TestFileSystemRename(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "a.txt", []byte("a"))
+	s.writeFile(t, "b.txt", []byte("b"))
+	s.writeFile(t, "dir/file", nil)
+
+	fs := newTestFileSystem(t, s, s.root)
+
+	var tests = []struct {
+		desc string
+		old  string
+		new  string
+		err  error
+	}{
+		{
+			desc: "same directory",
+			old:  "a.txt",
+			new:  "c.txt",
+		},
+		{
+			desc: "across directories",
+			old:  "c.txt",
+			new:  "dir/c.txt",
+		},
+		{
+			desc: "missing",
+			old:  "missing",
+			new:  "other",
+			err:  os.ErrNotExist,
+		},
+		{
+			desc: "rename root",
+			old:  "/",
+			new:  "moved",
+			err:  os.ErrInvalid,
+		},
+		{
+			desc: "replace root",
+			old:  "b.txt",
+			new:  "dir/..",
+			err:  os.ErrInvalid,
+		},
+	}
+
+	for i, tt := range tests {
+		err := fs.Rename(tt.old, tt.new)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("[%02d] test %q, unexpected error: %v != %v",
+					i, tt.desc, tt.err, err)
+			}
+
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to rename: %v", i, tt.desc, err)
+		}
+
+		if _, err := os.Lstat(filepath.FromSlash(s.path(tt.old))); !os.IsNotExist(err) {
+			t.Fatalf("[%02d] test %q, old file still exists: %v", i, tt.desc, err)
+		}
+		if _, err := os.Lstat(filepath.FromSlash(s.path(tt.new))); err != nil {
+			t.Fatalf("[%02d] test %q, new file does not exist: %v", i, tt.desc, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.FromSlash(s.path("b.txt"))); err != nil {
+		t.Fatalf("file renamed over root was removed: %v", err)
+	}
+}
+
+func TestFileSystemRemoveRoot(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.Mkdir(filepath.FromSlash(s.path("empty")), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	fs := newTestFileSystem(t, s, s.path("empty"))
+
+	for _, name := range []string{"/", ".", "..", "foo/.."} {
+		if err := fs.Remove(name); !errors.Is(err, os.ErrInvalid) {
+			t.Fatalf("unexpected error for root %q: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.FromSlash(s.path("empty"))); err != nil {
+		t.Fatalf("root directory was removed: %v", err)
+	}
+}
+
+func TestFileSystemHiddenDotfiles(t *testing.T) {
+	s := newTestServer(t)
+	for _, name := range []string{
+		"public/index.html",
+		"public/.env",
+		"public/.git/config",
+		"public/docs/.hidden",
+		"public/docs/readme.txt",
+	} {
+		s.writeFile(t, name, []byte(name))
+	}
+
+	var tests = []struct {
+		desc    string
+		hide    bool
+		entries []string
+		err     error
+	}{
+		{
+			desc:    "shown",
+			entries: []string{".env", ".git", "docs", "index.html"},
+		},
+		{
+			desc:    "hidden",
+			hide:    true,
+			entries: []string{"docs", "index.html"},
+			err:     os.ErrNotExist,
+		},
+	}
+
+	for i, tt := range tests {
+		fs := newTestFileSystem(t, s, s.path("public"), WithHiddenDotfiles(tt.hide))
+
+		f, err := fs.Open("/")
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to open directory: %v", i, tt.desc, err)
+		}
+		fis, err := f.Readdir(-1)
+		_ = f.Close()
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to read directory: %v", i, tt.desc, err)
+		}
+
+		var entries []string
+		for _, fi := range fis {
+			entries = append(entries, fi.Name())
+		}
+		if want, got := fmt.Sprint(tt.entries), fmt.Sprint(entries); want != got {
+			t.Fatalf("[%02d] test %q, unexpected entries: %v != %v",
+				i, tt.desc, want, got)
+		}
+
+		// Dotfiles, and anything inside of a dot directory, cannot be
+		// opened directly when hidden
+		for _, name := range []string{"/.env", "/.git/config", "/docs/.hidden"} {
+			f, err := fs.Open(name)
+			if err == nil {
+				_ = f.Close()
+			}
+			if want, got := tt.err, err; !errors.Is(got, want) {
+				t.Fatalf("[%02d] test %q, unexpected error for %q: %v != %v",
+					i, tt.desc, name, want, got)
+			}
+		}
+	}
+
+	// Hidden dotfiles receive 404 Not Found from http.FileServer
+	fs := newTestFileSystem(t, s, s.path("public"), WithHiddenDotfiles(true))
+	srv := httptest.NewServer(http.FileServer(fs))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/.env")
+	if err != nil {
+		t.Fatalf("failed to perform request: %v", err)
+	}
+	_ = res.Body.Close()
+	if want, got := http.StatusNotFound, res.StatusCode; want != got {
+		t.Fatalf("unexpected status code: %v != %v", want, got)
+	}
+}
+
+func TestFileSystemGlob(t *testing.T) {
+	s := newTestServer(t)
+	for _, name := range []string{
+		"root/logs/a.gz",
+		"root/logs/b.gz",
+		"root/logs/c.txt",
+		"root/logs/old/d.gz",
+		"root/data/x/1.csv",
+		"root/data/y/2.csv",
+		"root/data/y/3.txt",
+		"root/.hidden/e.gz",
+		"outside.gz",
+	} {
+		s.writeFile(t, name, []byte(name))
+	}
+
+	fs := newTestFileSystem(t, s, s.path("root"), WithDenyDotfiles(true))
+
+	var tests = []struct {
+		desc    string
+		pattern string
+		want    []string
+		err     error
+	}{
+		{
+			desc:    "single level",
+			pattern: "logs/*.gz",
+			want:    []string{"logs/a.gz", "logs/b.gz"},
+		},
+		{
+			desc:    "rooted",
+			pattern: "/logs/*.gz",
+			want:    []string{"/logs/a.gz", "/logs/b.gz"},
+		},
+		{
+			desc:    "directory spanning",
+			pattern: "data/*/*.csv",
+			want:    []string{"data/x/1.csv", "data/y/2.csv"},
+		},
+		{
+			desc:    "dotfiles denied",
+			pattern: "*/*.gz",
+			want:    []string{"logs/a.gz", "logs/b.gz"},
+		},
+		{
+			desc:    "literal",
+			pattern: "logs/c.txt",
+			want:    []string{"logs/c.txt"},
+		},
+		{
+			desc:    "literal missing",
+			pattern: "logs/missing.txt",
+		},
+		{
+			desc:    "no matches",
+			pattern: "logs/*.zip",
+		},
+		{
+			desc:    "confined to root",
+			pattern: "../*.gz",
+		},
+		{
+			desc:    "bad pattern",
+			pattern: "logs/[",
+			err:     path.ErrBadPattern,
+		},
+	}
+
+	for i, tt := range tests {
+		got, err := fs.Glob(tt.pattern)
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("[%02d] test %q, unexpected error: %v != %v",
+				i, tt.desc, tt.err, err)
+		}
+		if want, got := fmt.Sprint(tt.want), fmt.Sprint(got); want != got {
+			t.Fatalf("[%02d] test %q, unexpected matches: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
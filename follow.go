@@ -0,0 +1,227 @@
+package sshttp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// WithFollow configures a RoundTripper to allow clients to follow a growing
+// remote file, such as a log, in the same manner as tail -f.  Following is
+// requested using the follow query parameter, such as ?follow=1.  Once the
+// end of the file is reached, the file is polled for new data at the
+// specified interval, and the response body never ends until the request is
+// canceled or the response body is closed.
+//
+// Responses for followed files have no Content-Length, and are not subject
+// to the maximum size set by WithMaxFileSize.  Because followed responses
+// never end on their own, Shutdown waits for them until its context is
+// canceled.  Following is disabled by default.
+func WithFollow(interval time.Duration) Option {
+	return func(o *options) {
+		o.followInterval = interval
+	}
+}
+
+// wantsFollow reports whether r requests that a file be followed, using the
+// follow query parameter, such as ?follow=1.
+func wantsFollow(r *http.Request) bool {
+	ok, err := strconv.ParseBool(r.URL.Query().Get("follow"))
+	return err == nil && ok
+}
+
+// unsized serves the file named by r.URL.Path, described by fi, without a
+// Content-Length until the end of the file is reached.  If follow is set, the
+// response body never ends, and is extended as data is appended to the file,
+// which is polled at the interval follow.  Otherwise, regular files which turn
+// out to be empty are served with an empty body immediately, and files which
+// exceed the maximum size set by WithMaxFileSize are refused or truncated in
+// the same manner as by get.  Because the size of such files is not known in
+// advance, X-Original-Content-Length is only set for truncated files if the
+// remote host reports a size.
+//
+// As with files of known size, the X-Max-Bytes header limits the number of
+// bytes served.  If the file continues past the limit, or is followed, the
+// response is 206 Partial Content, with a Content-Range whose complete
+// length is unknown.  Trailers are sent as configured by WithTrailers, and
+// digests set by WithDigest are always sent as trailers, but only for
+// complete responses which are neither truncated nor followed.
+func (rt *RoundTripper) unsized(p *clientPair, r *http.Request, fi os.FileInfo, follow time.Duration) (*http.Response, error) {
+	limit, ok := maxBytes(r)
+	if !ok {
+		return rt.httpResponse(http.StatusBadRequest, nil, nil), nil
+	}
+
+	var f *sftp.File
+	err := rt.opts.retry(r.Context(), func() (err error) {
+		f, err = p.sftpc.Open(r.URL.Path)
+		return err
+	})
+	if err != nil {
+		return rt.errorResponse(r, err)
+	}
+
+	cType, err := rt.contentType(f, fi.Name())
+	if err != nil {
+		_ = f.Close()
+		return rt.errorResponse(r, err)
+	}
+
+	// Followed files change as they are served, so they are never cached,
+	// and ranges cannot be served for files of unknown size
+	h := http.Header{}
+	rt.setFileHeaders(h, r, fi, cType)
+	if follow > 0 {
+		h.Del("Last-Modified")
+		h.Del("Expires")
+		h.Set("Cache-Control", "no-store")
+	}
+	h.Set("Accept-Ranges", "none")
+
+	// Files in /proc may report no size despite having contents, so check
+	// whether a regular file is truly empty before streaming it
+	if follow <= 0 && fi.Mode().IsRegular() && !hasDataAt(f, 0) {
+		_ = f.Close()
+
+		h.Set("Content-Length", "0")
+		return rt.httpResponse(http.StatusOK, nil, h), nil
+	}
+
+	// Refuse or truncate files which exceed the maximum size, if set, which
+	// is determined by whether any data follows the maximum size
+	var (
+		src       io.Reader = f
+		truncated bool
+	)
+	if maxSize := rt.opts.maxSize; maxSize > 0 && follow <= 0 && hasDataAt(f, maxSize) {
+		if !rt.opts.truncate {
+			_ = f.Close()
+			return rt.httpResponse(http.StatusRequestEntityTooLarge, nil, nil), nil
+		}
+
+		if size := fi.Size(); size > maxSize {
+			h.Set("X-Original-Content-Length", strconv.FormatInt(size, 10))
+		}
+		src = io.LimitReader(f, maxSize)
+		truncated = true
+
+		if limit > maxSize {
+			limit = maxSize
+		}
+	}
+
+	// Serve only a prefix of the file if X-Max-Bytes is set and the file
+	// continues past it
+	code := http.StatusOK
+	if limit > 0 && (follow > 0 || hasDataAt(f, limit)) {
+		h.Set("Content-Range", fmt.Sprintf("bytes 0-%d/*", limit-1))
+		code = http.StatusPartialContent
+		src = io.LimitReader(f, limit)
+	}
+
+	var (
+		dt      *digestTrailer
+		trailer http.Header
+	)
+	if rt.opts.digest != "" && code == http.StatusOK && !truncated && follow <= 0 {
+		dt = rt.opts.newDigestTrailer(h)
+		trailer = dt.trailer
+	}
+	if rt.opts.trailers {
+		if trailer == nil {
+			trailer = http.Header{}
+		}
+
+		h.Add("Trailer", transferredBytesTrailer)
+		trailer[transferredBytesTrailer] = nil
+	}
+
+	body := rt.stream(r.Context(), p, func(ctx context.Context, w io.Writer) error {
+		defer f.Close()
+
+		cw := &countWriter{w: w}
+		var dst io.Writer = cw
+		if dt != nil {
+			dst = io.MultiWriter(cw, dt)
+		}
+
+		buf, put := rt.opts.buffer()
+		defer put()
+
+		if err := followFile(ctx, dst, src, follow, buf); err != nil {
+			return err
+		}
+
+		if dt != nil {
+			dt.finish()
+		}
+		if rt.opts.trailers {
+			trailer.Set(transferredBytesTrailer, strconv.FormatInt(cw.n, 10))
+		}
+		return nil
+	})
+
+	res := rt.httpResponse(code, body, h)
+	res.Trailer = trailer
+
+	return res, nil
+}
+
+// hasDataAt reports whether f has any data at the offset off.
+func hasDataAt(f io.ReaderAt, off int64) bool {
+	var b [1]byte
+	n, _ := f.ReadAt(b[:], off)
+	return n > 0
+}
+
+// followFile copies f to w using buf, or a new buffer if buf is nil.  If
+// interval is set, followFile then polls f for new data at the specified
+// interval, until ctx is canceled, an error occurs, or f is an
+// io.LimitedReader whose limit is reached.  Otherwise, it returns once the
+// end of f is reached.
+func followFile(ctx context.Context, w io.Writer, f io.Reader, interval time.Duration, buf []byte) error {
+	var tick <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	if buf == nil {
+		buf = make([]byte, 32*1024)
+	}
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+
+		switch {
+		case err == io.EOF:
+			if lr, ok := f.(*io.LimitedReader); tick == nil || (ok && lr.N <= 0) {
+				return nil
+			}
+
+			// Wait for more data to be appended
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-tick:
+			}
+		case err != nil:
+			if cerr := ctx.Err(); cerr != nil {
+				return cerr
+			}
+
+			return err
+		}
+	}
+}
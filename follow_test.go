@@ -0,0 +1,238 @@
+package sshttp
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRoundTripperGetUnsized(t *testing.T) {
+	// Files in /proc report no size, and are served without one
+	const proc = "/proc/self/cmdline"
+	file, err := os.ReadFile(proc)
+	if err != nil || len(file) < 8 {
+		t.Skipf("skipping, %s is not available: %v", proc, err)
+	}
+	sum := sha256.Sum256(file)
+
+	s := newTestServer(t)
+	if err := os.Symlink(proc, filepath.FromSlash(s.path("proc"))); err != nil {
+		t.Fatalf("failed to create symbolic link: %v", err)
+	}
+
+	var tests = []struct {
+		desc   string
+		opts   []Option
+		limit  string
+		code   int
+		cRange string
+		body   []byte
+		digest string
+	}{
+		{
+			desc:   "entire file",
+			code:   http.StatusOK,
+			body:   file,
+			digest: "sha-256=" + base64.StdEncoding.EncodeToString(sum[:]),
+		},
+		{
+			desc:   "X-Max-Bytes",
+			limit:  "4",
+			code:   http.StatusPartialContent,
+			cRange: "bytes 0-3/*",
+			body:   file[:4],
+		},
+		{
+			desc:   "X-Max-Bytes larger than file",
+			limit:  "1000000",
+			code:   http.StatusOK,
+			body:   file,
+			digest: "sha-256=" + base64.StdEncoding.EncodeToString(sum[:]),
+		},
+		{
+			desc: "truncated",
+			opts: []Option{WithMaxFileSize(4, true)},
+			code: http.StatusOK,
+			body: file[:4],
+		},
+		{
+			desc:   "truncated with X-Max-Bytes",
+			opts:   []Option{WithMaxFileSize(4, true)},
+			limit:  "2",
+			code:   http.StatusPartialContent,
+			cRange: "bytes 0-1/*",
+			body:   file[:2],
+		},
+		{
+			desc:  "malformed X-Max-Bytes",
+			limit: "-1",
+			code:  http.StatusBadRequest,
+		},
+	}
+
+	for i, tt := range tests {
+		rt := newTestRoundTripper(t, append(tt.opts,
+			WithDigest("sha-256"),
+			WithTrailers(true),
+			WithBufferSize(3),
+		)...)
+
+		r := newRequest(t, http.MethodGet, s.url("proc"))
+		if tt.limit != "" {
+			r.Header.Set(maxBytesHeader, tt.limit)
+		}
+		res, body := do(t, rt, r)
+
+		if want, got := tt.code, res.StatusCode; want != got {
+			t.Fatalf("[%02d] test %q, unexpected status code: %v != %v",
+				i, tt.desc, want, got)
+		}
+		if tt.body == nil {
+			continue
+		}
+
+		if want, got := "", res.Header.Get("Content-Length"); want != got {
+			t.Fatalf("[%02d] test %q, unexpected Content-Length: %q != %q",
+				i, tt.desc, want, got)
+		}
+		if want, got := tt.cRange, res.Header.Get("Content-Range"); want != got {
+			t.Fatalf("[%02d] test %q, unexpected Content-Range: %q != %q",
+				i, tt.desc, want, got)
+		}
+		if want, got := string(tt.body), string(body); want != got {
+			t.Fatalf("[%02d] test %q, unexpected body: %q != %q",
+				i, tt.desc, want, got)
+		}
+
+		if want, got := strconv.Itoa(len(tt.body)), res.Trailer.Get(transferredBytesTrailer); want != got {
+			t.Fatalf("[%02d] test %q, unexpected transferred bytes: %q != %q",
+				i, tt.desc, want, got)
+		}
+		if want, got := tt.digest, res.Trailer.Get("Digest"); want != got {
+			t.Fatalf("[%02d] test %q, unexpected digest: %q != %q",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestRoundTripperFollowMaxBytes(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "file.log", []byte("hello world"))
+
+	rt := newTestRoundTripper(t,
+		WithFollow(10*time.Millisecond),
+		WithDigest("sha-256"),
+		WithTrailers(true),
+	)
+
+	// X-Max-Bytes ends a followed response once the limit is reached
+	r := newRequest(t, http.MethodGet, s.url("file.log")+"?follow=1")
+	r.Header.Set(maxBytesHeader, "5")
+
+	res, err := (&http.Client{Transport: rt}).Do(r)
+	if err != nil {
+		t.Fatalf("failed to perform request: %v", err)
+	}
+	defer res.Body.Close()
+
+	if want, got := http.StatusPartialContent, res.StatusCode; want != got {
+		t.Fatalf("unexpected status code: %v != %v", want, got)
+	}
+	if want, got := "bytes 0-4/*", res.Header.Get("Content-Range"); want != got {
+		t.Fatalf("unexpected Content-Range: %q != %q", want, got)
+	}
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	if want, got := "hello", string(b); want != got {
+		t.Fatalf("unexpected body: %q != %q", want, got)
+	}
+
+	if want, got := "5", res.Trailer.Get(transferredBytesTrailer); want != got {
+		t.Fatalf("unexpected transferred bytes: %q != %q", want, got)
+	}
+	if v := res.Trailer.Get("Digest"); v != "" {
+		t.Fatalf("unexpected digest for followed file: %q", v)
+	}
+}
+
+func TestRoundTripperFollow(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "file.log", []byte("hello"))
+
+	rt := newTestRoundTripper(t, WithFollow(10*time.Millisecond))
+	res, err := (&http.Client{Transport: rt}).Get(s.url("file.log") + "?follow=1")
+	if err != nil {
+		t.Fatalf("failed to perform request: %v", err)
+	}
+
+	if want, got := http.StatusOK, res.StatusCode; want != got {
+		t.Fatalf("unexpected status code: %v != %v", want, got)
+	}
+	if want, got := int64(-1), res.ContentLength; want != got {
+		t.Fatalf("unexpected content length: %v != %v", want, got)
+	}
+	if want, got := "no-store", res.Header.Get("Cache-Control"); want != got {
+		t.Fatalf("unexpected Cache-Control: %q != %q", want, got)
+	}
+
+	// read reads exactly n bytes from the followed body
+	read := func(n int) string {
+		t.Helper()
+
+		b := make([]byte, n)
+		if _, err := io.ReadFull(res.Body, b); err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+
+		return string(b)
+	}
+
+	if want, got := "hello", read(5); want != got {
+		t.Fatalf("unexpected body: %q != %q", want, got)
+	}
+
+	// Data appended to the file is streamed as it arrives
+	for _, v := range []string{" world", "!\n"} {
+		f, err := os.OpenFile(filepath.FromSlash(s.path("file.log")), os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatalf("failed to open file: %v", err)
+		}
+		if _, err := f.WriteString(v); err != nil {
+			t.Fatalf("failed to append to file: %v", err)
+		}
+		_ = f.Close()
+
+		if want, got := v, read(len(v)); want != got {
+			t.Fatalf("unexpected appended data: %q != %q", want, got)
+		}
+	}
+
+	// Closing the body ends the transfer
+	_ = res.Body.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for rt.Stats().InFlight[s.addr] != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("transfer did not end after the body was closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Following must be enabled to be requested
+	rt = newTestRoundTripper(t)
+	res, body := do(t, rt, newRequest(t, http.MethodGet, s.url("file.log")+"?follow=1"))
+	if want, got := "13", res.Header.Get("Content-Length"); want != got {
+		t.Fatalf("unexpected Content-Length: %q != %q", want, got)
+	}
+	if want, got := "hello world!\n", string(body); want != got {
+		t.Fatalf("unexpected body: %q != %q", want, got)
+	}
+}
@@ -0,0 +1,16 @@
+module github.com/mdlayher/sshttp
+
+go 1.26.0
+
+require (
+	github.com/pkg/sftp v1.13.11
+	golang.org/x/crypto v0.56.0
+	golang.org/x/net v0.57.0
+	golang.org/x/sync v0.9.0
+	golang.org/x/time v0.9.0
+)
+
+require (
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/sys v0.48.0 // indirect
+)
@@ -0,0 +1,169 @@
+package sshttp
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultCooldown is the duration for which a failed host in a group is
+// skipped, if none is specified using WithCooldown.
+const defaultCooldown = 30 * time.Second
+
+// WithCooldown configures the duration for which a RoundTripper skips a host
+// in a group registered using DialGroup after the host fails.  The default
+// is 30 seconds.
+func WithCooldown(d time.Duration) Option {
+	return func(o *options) {
+		o.cooldown = d
+	}
+}
+
+// WithRoundRobin configures a RoundTripper to distribute successive requests
+// for a group registered using DialGroup across each of the group's hosts in
+// turn, rather than preferring the first available host.  This is useful
+// when the hosts are equivalent replicas serving the same files.  Hosts
+// which are marked down are still skipped.
+func WithRoundRobin() Option {
+	return func(o *options) {
+		o.roundRobin = true
+	}
+}
+
+// hostGroup is a group of physical hosts which serve a single logical host.
+type hostGroup struct {
+	hosts      []string
+	config     *ssh.ClientConfig
+	cooldown   time.Duration
+	roundRobin bool
+
+	// Time until which each failed host is skipped, and the index of the
+	// next host to try first when using round-robin
+	mu   sync.Mutex
+	down map[string]time.Time
+	next int
+}
+
+// DialGroup registers name as a logical host which is served by each of the
+// physical hosts in hosts, such as the members of a highly available SFTP
+// cluster, and dials each host using the specified SSH client configuration.
+// If the config parameter is nil, the default set by NewRoundTripper will be
+// used.
+//
+// Requests for name are served by the first host in hosts which is not
+// marked down, or by each host in turn if WithRoundRobin is set.  A host
+// which cannot be dialed or fails to serve a request due to a
+// connection-level error is marked down and its connection is closed.  It
+// is skipped until the cooldown set by WithCooldown expires, unless every
+// host in the group is down, and is then dialed again.
+// DialGroup only returns an error if none of the hosts can be dialed.
+func (rt *RoundTripper) DialGroup(name string, hosts []string, config *ssh.ClientConfig) error {
+	if len(hosts) == 0 {
+		return errors.New("sshttp: no hosts specified for group")
+	}
+
+	cooldown := rt.opts.cooldown
+	if cooldown <= 0 {
+		cooldown = defaultCooldown
+	}
+
+	g := &hostGroup{
+		hosts:      hosts,
+		config:     config,
+		cooldown:   cooldown,
+		roundRobin: rt.opts.roundRobin,
+		down:       make(map[string]time.Time),
+	}
+
+	rt.mu.Lock()
+	rt.groups[name] = g
+	rt.mu.Unlock()
+
+	var errs []error
+	for _, host := range hosts {
+		if err := rt.Dial(host, config); err != nil {
+			g.markDown(host)
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == len(hosts) {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// group returns the group registered for the logical host name, if any.
+func (rt *RoundTripper) group(name string) (*hostGroup, bool) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	g, ok := rt.groups[name]
+	return g, ok
+}
+
+// candidates returns the hosts in g in the order they should be tried.
+// Hosts which are marked down are tried last, only if all others fail.  If
+// advance is set and g uses round-robin, the next call starts with the
+// following host.
+func (g *hostGroup) candidates(advance bool) []string {
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Rotate the starting host for each request when using round-robin
+	start := 0
+	if g.roundRobin {
+		start = g.next
+		if advance {
+			g.next = (g.next + 1) % len(g.hosts)
+		}
+	}
+
+	up := make([]string, 0, len(g.hosts))
+	var down []string
+	for i := range g.hosts {
+		host := g.hosts[(start+i)%len(g.hosts)]
+		if until, ok := g.down[host]; ok && now.Before(until) {
+			down = append(down, host)
+			continue
+		}
+
+		up = append(up, host)
+	}
+
+	return append(up, down...)
+}
+
+// markDown marks host as down until the group's cooldown expires.
+func (g *hostGroup) markDown(host string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.down[host] = time.Now().Add(g.cooldown)
+}
+
+// markDown marks host in g as down, and closes any connection to host, so
+// that host is dialed again once its cooldown expires rather than reusing a
+// connection which may be broken.
+func (rt *RoundTripper) markDown(g *hostGroup, host string) {
+	g.markDown(host)
+
+	rt.mu.RLock()
+	p, ok := rt.conn[host]
+	rt.mu.RUnlock()
+	if ok {
+		rt.evict(host, p)
+	}
+}
+
+// markUp marks host as available.
+func (g *hostGroup) markUp(host string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.down, host)
+}
@@ -0,0 +1,57 @@
+package sshttp
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRoundTripperDialGroupRestartedHost(t *testing.T) {
+	const cooldown = 100 * time.Millisecond
+
+	a, b := newTestServer(t), newTestServer(t)
+	a.writeFile(t, "file", []byte("hello"))
+
+	rt := newTestRoundTripper(t, WithCooldown(cooldown))
+	if err := rt.DialGroup("group", []string{a.addr, b.addr}, nil); err != nil {
+		t.Fatalf("failed to dial group: %v", err)
+	}
+
+	// Both hosts serve the same local files, so the group can be served by
+	// either of them
+	u := Protocol + "://group" + a.path("file")
+	get := func() {
+		t.Helper()
+
+		res, body := do(t, rt, newRequest(t, http.MethodGet, u))
+		if want, got := http.StatusOK, res.StatusCode; want != got {
+			t.Fatalf("unexpected status code: %v != %v", want, got)
+		}
+		if want, got := "hello", string(body); want != got {
+			t.Fatalf("unexpected body: %q != %q", want, got)
+		}
+	}
+
+	get()
+
+	// The first host fails, so the request is served by the second, and
+	// the first host's connection is closed
+	a.stop()
+	get()
+
+	for _, host := range rt.Hosts() {
+		if host == a.addr {
+			t.Fatalf("connection to failed host %q was not closed", host)
+		}
+	}
+
+	// Once the cooldown expires, the restarted host is dialed again and
+	// serves the request
+	restarted := listenTestServer(t, a.addr)
+	time.Sleep(cooldown)
+	get()
+
+	if want, got := int32(1), restarted.dials.Load(); want != got {
+		t.Fatalf("unexpected number of dials to restarted host: %v != %v", want, got)
+	}
+}
@@ -0,0 +1,199 @@
+package sshttp
+
+import (
+	"container/list"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// WithHandleCache configures a RoundTripper to keep up to capacity remote
+// files open on each connection, so that repeated requests for the same file
+// need not open it over SFTP each time.  The least recently used file is
+// closed when the capacity is exceeded, and all cached files are closed when
+// their connection is closed.  A capacity of zero or less disables caching,
+// which is the default.
+//
+// Each request still describes the file over SFTP, and a cached file is
+// reopened if the file's size or modification time has changed since it was
+// opened, such as when it is replaced on the remote host.  Files which are
+// modified in place without changing either may continue to be served with
+// their previous contents, so the cache is best suited for files which do
+// not change.
+func WithHandleCache(capacity int) Option {
+	return func(o *options) {
+		o.handleCacheSize = capacity
+	}
+}
+
+// A handleCache is a bounded LRU cache of open remote files.  Files which are
+// evicted while in use are closed once they are released.
+type handleCache struct {
+	capacity int
+
+	mu sync.Mutex
+	ll *list.List
+	m  map[string]*list.Element
+}
+
+// A handleEntry is an open remote file in a handleCache, along with the size
+// and modification time of the file when it was opened.
+type handleEntry struct {
+	name    string
+	f       *sftp.File
+	size    int64
+	modTime time.Time
+	refs    int
+	evicted bool
+}
+
+// matches reports whether e was opened for a file described by fi.
+func (e *handleEntry) matches(fi os.FileInfo) bool {
+	return e.size == fi.Size() && e.modTime.Equal(fi.ModTime())
+}
+
+// newHandleCache creates a handleCache with the specified capacity.  If the
+// capacity is zero or less, it returns nil, which disables caching.
+func newHandleCache(capacity int) *handleCache {
+	if capacity <= 0 {
+		return nil
+	}
+
+	return &handleCache{
+		capacity: capacity,
+		ll:       list.New(),
+		m:        make(map[string]*list.Element),
+	}
+}
+
+// acquire returns an open remote file for name, which is currently described
+// by fi, opening it using sftpc if it is not already cached or if the cached
+// file was opened for a different version of the file.  The returned function
+// must be called once the file is no longer in use.  Because the file may be
+// shared by concurrent callers, it must only be read using ReadAt.
+func (c *handleCache) acquire(sftpc *sftp.Client, name string, fi os.FileInfo) (*sftp.File, func(), error) {
+	if f, release, ok := c.get(name, fi); ok {
+		return f, release, nil
+	}
+
+	// Open the file without holding the lock, since it requires a round
+	// trip to the server
+	f, err := sftpc.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Another caller may have opened the same file concurrently, in which
+	// case its file is shared instead, unless it is for another version of
+	// the file
+	if el, ok := c.m[name]; ok {
+		if el.Value.(*handleEntry).matches(fi) {
+			_ = f.Close()
+			return c.use(el)
+		}
+
+		c.evict(el)
+	}
+
+	el := c.ll.PushFront(&handleEntry{
+		name:    name,
+		f:       f,
+		size:    fi.Size(),
+		modTime: fi.ModTime(),
+	})
+	c.m[name] = el
+
+	for c.ll.Len() > c.capacity {
+		c.evict(c.ll.Back())
+	}
+
+	return c.use(el)
+}
+
+// get returns a cached file for name, if present and opened for the version
+// of the file described by fi.  Cached files for other versions are evicted.
+func (c *handleCache) get(name string, fi os.FileInfo) (*sftp.File, func(), bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.m[name]
+	if !ok {
+		return nil, nil, false
+	}
+	if !el.Value.(*handleEntry).matches(fi) {
+		c.evict(el)
+		return nil, nil, false
+	}
+
+	f, release, _ := c.use(el)
+	return f, release, true
+}
+
+// use marks the entry in el as recently used and in use by another caller.
+// The lock must be held when use is called.
+func (c *handleCache) use(el *list.Element) (*sftp.File, func(), error) {
+	c.ll.MoveToFront(el)
+
+	e := el.Value.(*handleEntry)
+	e.refs++
+
+	var once sync.Once
+	return e.f, func() {
+		once.Do(func() { c.release(e) })
+	}, nil
+}
+
+// release marks e as no longer in use by a caller, closing its file if it
+// has been evicted and is no longer in use.
+func (c *handleCache) release(e *handleEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e.refs--
+	if e.evicted && e.refs == 0 {
+		_ = e.f.Close()
+	}
+}
+
+// evict removes the entry in el from the cache, closing its file if it is
+// not in use.  The lock must be held when evict is called.
+func (c *handleCache) evict(el *list.Element) {
+	e := el.Value.(*handleEntry)
+
+	c.ll.Remove(el)
+	delete(c.m, e.name)
+
+	e.evicted = true
+	if e.refs == 0 {
+		_ = e.f.Close()
+	}
+}
+
+// close evicts all entries from the cache.
+func (c *handleCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.ll.Len() > 0 {
+		c.evict(c.ll.Back())
+	}
+}
+
+// cachedFile is a remote file acquired from a handleCache, which is read
+// using a section reader so it can safely be shared by concurrent requests.
+type cachedFile struct {
+	*io.SectionReader
+	release func()
+}
+
+// Close releases the file back to its handleCache.
+func (f *cachedFile) Close() error {
+	f.release()
+	return nil
+}
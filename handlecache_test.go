@@ -0,0 +1,172 @@
+package sshttp
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// isClosed reports whether the remote file f has been closed.
+func isClosed(f *sftp.File) bool {
+	_, err := f.ReadAt(make([]byte, 1), 0)
+	return errors.Is(err, os.ErrClosed)
+}
+
+func TestHandleCacheDisabled(t *testing.T) {
+	if c := newHandleCache(0); c != nil {
+		t.Fatal("expected nil handleCache for zero capacity")
+	}
+}
+
+func TestHandleCacheShared(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "foo", []byte("foo"))
+	sftpc := s.client(t)
+
+	name := s.path("foo")
+	fi, err := sftpc.Stat(name)
+	if err != nil {
+		t.Fatalf("failed to stat: %v", err)
+	}
+
+	c := newHandleCache(2)
+	f1, release1, err := c.acquire(sftpc, name, fi)
+	if err != nil {
+		t.Fatalf("failed to acquire: %v", err)
+	}
+	f2, release2, err := c.acquire(sftpc, name, fi)
+	if err != nil {
+		t.Fatalf("failed to acquire: %v", err)
+	}
+
+	if f1 != f2 {
+		t.Fatal("expected cached file to be shared")
+	}
+	if want, got := 2, c.m[name].Value.(*handleEntry).refs; want != got {
+		t.Fatalf("unexpected reference count: %v != %v", want, got)
+	}
+
+	// Releasing more than once has no further effect, and cached files
+	// remain open once released
+	release1()
+	release1()
+	release2()
+
+	if want, got := 0, c.m[name].Value.(*handleEntry).refs; want != got {
+		t.Fatalf("unexpected reference count: %v != %v", want, got)
+	}
+	if isClosed(f1) {
+		t.Fatal("cached file was closed")
+	}
+
+	c.close()
+	if !isClosed(f1) {
+		t.Fatal("file was not closed when cache was closed")
+	}
+}
+
+func TestHandleCacheEvictInUse(t *testing.T) {
+	s := newTestServer(t)
+	sftpc := s.client(t)
+
+	c := newHandleCache(1)
+
+	var (
+		files    []*sftp.File
+		releases []func()
+	)
+	for _, name := range []string{"foo", "bar"} {
+		s.writeFile(t, name, []byte(name))
+
+		fi, err := sftpc.Stat(s.path(name))
+		if err != nil {
+			t.Fatalf("failed to stat: %v", err)
+		}
+
+		f, release, err := c.acquire(sftpc, s.path(name), fi)
+		if err != nil {
+			t.Fatalf("failed to acquire: %v", err)
+		}
+
+		files = append(files, f)
+		releases = append(releases, release)
+	}
+
+	// foo was evicted to make room for bar, but is still in use
+	if _, ok := c.m[s.path("foo")]; ok {
+		t.Fatal("least recently used file was not evicted")
+	}
+	if isClosed(files[0]) {
+		t.Fatal("evicted file was closed while in use")
+	}
+
+	releases[0]()
+	if !isClosed(files[0]) {
+		t.Fatal("evicted file was not closed once released")
+	}
+
+	releases[1]()
+	if isClosed(files[1]) {
+		t.Fatal("cached file was closed")
+	}
+	c.close()
+}
+
+func TestHandleCacheReopensChangedFile(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "foo", []byte("foo"))
+	sftpc := s.client(t)
+
+	name := s.path("foo")
+	fi, err := sftpc.Stat(name)
+	if err != nil {
+		t.Fatalf("failed to stat: %v", err)
+	}
+
+	c := newHandleCache(2)
+	f1, release1, err := c.acquire(sftpc, name, fi)
+	if err != nil {
+		t.Fatalf("failed to acquire: %v", err)
+	}
+
+	// Replace the file, changing its size
+	if err := os.Remove(name); err != nil {
+		t.Fatalf("failed to remove file: %v", err)
+	}
+	s.writeFile(t, "foo", []byte("foobar"))
+
+	fi, err = sftpc.Stat(name)
+	if err != nil {
+		t.Fatalf("failed to stat: %v", err)
+	}
+
+	f2, release2, err := c.acquire(sftpc, name, fi)
+	if err != nil {
+		t.Fatalf("failed to acquire: %v", err)
+	}
+	if f1 == f2 {
+		t.Fatal("cached file was reused for changed file")
+	}
+
+	b := make([]byte, 6)
+	if _, err := f2.ReadAt(b, 0); err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if want, got := "foobar", string(b); want != got {
+		t.Fatalf("unexpected contents: %q != %q", want, got)
+	}
+
+	// The previous file is closed once its last user releases it
+	if isClosed(f1) {
+		t.Fatal("replaced file was closed while in use")
+	}
+	release1()
+	if !isClosed(f1) {
+		t.Fatal("replaced file was not closed once released")
+	}
+
+	release2()
+	c.close()
+}
@@ -0,0 +1,122 @@
+package sshttp
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Handler creates a FileHandler which serves the remote files under host
+// over HTTP, as if they were local.  The host and configuration parameters,
+// along with any Option values, are used in the same manner as with
+// NewFileSystem.
+//
+// Files are served using http.FileServer, so requests for missing files
+// receive 404 Not Found, requests for inaccessible files receive 403
+// Forbidden, and other failures receive 500 Internal Server Error.  Error
+// responses only contain the status text, so that details of the remote
+// host are not revealed to clients.  The FileHandler should be closed once
+// it is no longer needed, to close the underlying SFTP and SSH connections.
+func Handler(host string, config *ssh.ClientConfig, opts ...Option) (*FileHandler, error) {
+	fs, err := NewFileSystem(host, config, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileHandler{
+		fs: fs,
+		h:  http.FileServer(fs),
+	}, nil
+}
+
+var (
+	_ http.Handler = &FileHandler{}
+	_ io.Closer    = &FileHandler{}
+)
+
+// A FileHandler is a http.Handler which serves files from a FileSystem.
+// FileHandlers are created using Handler.
+type FileHandler struct {
+	fs *FileSystem
+	h  http.Handler
+}
+
+// ServeHTTP implements http.Handler.
+func (h *FileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.h.ServeHTTP(w, r)
+}
+
+// Close implements io.Closer, and closes the FileHandler's connections to
+// the remote host.
+func (h *FileHandler) Close() error {
+	return h.fs.Close()
+}
+
+// Handler returns a http.Handler which serves each incoming request by
+// performing a RoundTrip for the same path and query against host, and
+// copying the resulting status, headers, body, and trailers to the client.
+// This allows the files on a remote host to be mounted under a route of a
+// HTTP server, in the same manner as a reverse proxy.
+//
+// Response bodies are streamed to the client as they are read, and requests
+// are canceled if the client disconnects.  If the request cannot be
+// performed, the client receives 502 Bad Gateway, or 404 Not Found for
+// missing files reported as errors due to WithNotFoundError, or 503 Service
+// Unavailable once the RoundTripper is shut down.  Such responses only
+// contain the status text, so that internal errors are not revealed to
+// clients.
+func (rt *RoundTripper) Handler(host string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out := r.Clone(r.Context())
+		out.URL = &url.URL{
+			Scheme:   Protocol,
+			Host:     host,
+			Path:     r.URL.Path,
+			RawQuery: r.URL.RawQuery,
+		}
+		out.Host = host
+		out.RequestURI = ""
+
+		res, err := rt.RoundTrip(out)
+		if err != nil {
+			code := http.StatusBadGateway
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				code = http.StatusNotFound
+			case errors.Is(err, ErrClosed):
+				code = http.StatusServiceUnavailable
+			}
+
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+		defer res.Body.Close()
+
+		// Connection management is left to the HTTP server.  Trailers are
+		// only sent if the response is chunked, which it cannot be if its
+		// length is specified.
+		for k, v := range res.Header {
+			if k == "Connection" || (k == "Content-Length" && len(res.Trailer) > 0) {
+				continue
+			}
+
+			w.Header()[k] = append([]string(nil), v...)
+		}
+		w.WriteHeader(res.StatusCode)
+
+		// Abort the response on failure, so the client cannot mistake a
+		// partial body for a complete one
+		if _, err := io.Copy(w, res.Body); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+
+		// Trailers are only populated once the body is read
+		for k, v := range res.Trailer {
+			w.Header()[k] = append([]string(nil), v...)
+		}
+	})
+}
@@ -0,0 +1,209 @@
+package sshttp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "public/file.txt", []byte("hello"))
+	s.writeFile(t, "public/secret.txt", []byte("secret"))
+
+	h, err := Handler(
+		Protocol+"://"+s.addr+s.path("public"),
+		testClientConfig(),
+		WithDenyGlobs("/secret.txt"),
+	)
+	if err != nil {
+		t.Fatalf("failed to create Handler: %v", err)
+	}
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	get := func(name string) (int, string) {
+		t.Helper()
+
+		res, err := http.Get(srv.URL + name)
+		if err != nil {
+			t.Fatalf("failed to perform request: %v", err)
+		}
+		defer res.Body.Close()
+
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+
+		return res.StatusCode, string(b)
+	}
+
+	var tests = []struct {
+		desc  string
+		name  string
+		close bool
+		code  int
+		body  string
+	}{
+		{
+			desc: "OK",
+			name: "/file.txt",
+			code: http.StatusOK,
+			body: "hello",
+		},
+		{
+			desc: "not found",
+			name: "/missing.txt",
+			code: http.StatusNotFound,
+			body: "404 page not found\n",
+		},
+		{
+			desc: "forbidden",
+			name: "/secret.txt",
+			code: http.StatusForbidden,
+			body: "403 Forbidden\n",
+		},
+		{
+			desc:  "closed",
+			name:  "/file.txt",
+			close: true,
+			code:  http.StatusInternalServerError,
+			body:  "500 Internal Server Error\n",
+		},
+	}
+
+	for i, tt := range tests {
+		if tt.close {
+			if err := h.Close(); err != nil {
+				t.Fatalf("[%02d] test %q, failed to close Handler: %v", i, tt.desc, err)
+			}
+		}
+
+		code, body := get(tt.name)
+		if want, got := tt.code, code; want != got {
+			t.Fatalf("[%02d] test %q, unexpected status code: %v != %v",
+				i, tt.desc, want, got)
+		}
+		if want, got := tt.body, body; want != got {
+			t.Fatalf("[%02d] test %q, unexpected body: %q != %q",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestRoundTripperHandler(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "file", []byte("hello world"))
+
+	// Reserve an address which nothing is listening on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	down := l.Addr().String()
+	_ = l.Close()
+
+	rt := newTestRoundTripper(t, WithTrailers(true))
+	srv := httptest.NewServer(rt.Handler(s.addr))
+	defer srv.Close()
+	downSrv := httptest.NewServer(rt.Handler(down))
+	defer downSrv.Close()
+
+	var tests = []struct {
+		desc    string
+		url     string
+		rng     string
+		code    int
+		body    string
+		trailer string
+	}{
+		{
+			desc:    "OK",
+			url:     srv.URL + s.path("file"),
+			code:    http.StatusOK,
+			body:    "hello world",
+			trailer: "11",
+		},
+		{
+			desc:    "range",
+			url:     srv.URL + s.path("file"),
+			rng:     "bytes=6-",
+			code:    http.StatusPartialContent,
+			body:    "world",
+			trailer: "5",
+		},
+		{
+			desc: "not found",
+			url:  srv.URL + s.path("missing"),
+			code: http.StatusNotFound,
+		},
+		{
+			desc: "host down",
+			url:  downSrv.URL + s.path("file"),
+			code: http.StatusBadGateway,
+			body: "Bad Gateway\n",
+		},
+	}
+
+	for i, tt := range tests {
+		r, err := http.NewRequest(http.MethodGet, tt.url, nil)
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to create request: %v", i, tt.desc, err)
+		}
+		if tt.rng != "" {
+			r.Header.Set("Range", tt.rng)
+		}
+
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to perform request: %v", i, tt.desc, err)
+		}
+		b, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to read body: %v", i, tt.desc, err)
+		}
+
+		if want, got := tt.code, res.StatusCode; want != got {
+			t.Fatalf("[%02d] test %q, unexpected status code: %v != %v",
+				i, tt.desc, want, got)
+		}
+		if tt.body != "" {
+			if want, got := tt.body, string(b); want != got {
+				t.Fatalf("[%02d] test %q, unexpected body: %q != %q",
+					i, tt.desc, want, got)
+			}
+		}
+		if want, got := tt.trailer, res.Trailer.Get(transferredBytesTrailer); want != got {
+			t.Fatalf("[%02d] test %q, unexpected trailer: %q != %q",
+				i, tt.desc, want, got)
+		}
+	}
+
+	// Once the RoundTripper is shut down, clients are told the service is
+	// unavailable, without the details of the error
+	if err := rt.Shutdown(context.Background()); err != nil {
+		t.Fatalf("failed to shut down: %v", err)
+	}
+
+	res, err := http.Get(srv.URL + s.path("file"))
+	if err != nil {
+		t.Fatalf("failed to perform request: %v", err)
+	}
+	b, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	if want, got := http.StatusServiceUnavailable, res.StatusCode; want != got {
+		t.Fatalf("unexpected status code: %v != %v", want, got)
+	}
+	if want, got := "Service Unavailable\n", string(b); want != got {
+		t.Fatalf("unexpected body: %q != %q", want, got)
+	}
+}
@@ -0,0 +1,86 @@
+package sshttp
+
+import (
+	"errors"
+	"net/http"
+)
+
+// WithRequestHook configures a RoundTripper to call fn with each request
+// before it is served, such as to check authorization, rewrite paths, or log
+// requests.  fn returns the request to serve, which must not be nil, and may
+// be the same request or a modified copy.  Hooks are called in the order
+// they are configured, each receiving the request returned by the previous
+// hook.
+//
+// If fn returns an error, the request is not served, and RoundTrip returns
+// the error.  To respond to a request without serving it, such as with 401
+// Unauthorized, fn may return a *ShortCircuit error containing the response.
+// A hook which returns a nil request without an error, or a ShortCircuit
+// with a nil Response, causes RoundTrip to return an error.
+func WithRequestHook(fn func(r *http.Request) (*http.Request, error)) Option {
+	return func(o *options) {
+		o.requestHooks = append(o.requestHooks, fn)
+	}
+}
+
+// WithResponseHook configures a RoundTripper to call fn with each response
+// it produces before it is returned, including responses returned by request
+// hooks using ShortCircuit.  Hooks are called in the order they are
+// configured.  fn must not read or close the response body.
+func WithResponseHook(fn func(res *http.Response)) Option {
+	return func(o *options) {
+		o.responseHooks = append(o.responseHooks, fn)
+	}
+}
+
+// A ShortCircuit is an error which may be returned by a request hook set
+// using WithRequestHook to respond to a request with Response, without
+// serving the request.
+type ShortCircuit struct {
+	Response *http.Response
+}
+
+// Error implements error.
+func (s *ShortCircuit) Error() string {
+	return "sshttp: request short-circuited by hook"
+}
+
+// Errors returned when a request hook returns neither a request nor a
+// response to serve.
+var (
+	errNilHookRequest  = errors.New("sshttp: request hook returned nil request")
+	errNilHookResponse = errors.New("sshttp: request hook short-circuited with nil response")
+)
+
+// runRequestHooks applies each request hook to r.  If a hook
+// short-circuits the request, runRequestHooks returns its response.
+func (o *options) runRequestHooks(r *http.Request) (*http.Request, *http.Response, error) {
+	for _, fn := range o.requestHooks {
+		var err error
+		r, err = fn(r)
+
+		var sc *ShortCircuit
+		if errors.As(err, &sc) {
+			if sc.Response == nil {
+				return nil, nil, errNilHookResponse
+			}
+
+			return nil, sc.Response, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if r == nil {
+			return nil, nil, errNilHookRequest
+		}
+	}
+
+	return r, nil, nil
+}
+
+// runResponseHooks applies each response hook to res.
+func (o *options) runResponseHooks(res *http.Response) {
+	for _, fn := range o.responseHooks {
+		fn(res)
+	}
+}
@@ -0,0 +1,70 @@
+package sshttp
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestRoundTripperRequestHooks(t *testing.T) {
+	errHook := errors.New("hook failed")
+
+	var tests = []struct {
+		desc string
+		hook func(r *http.Request) (*http.Request, error)
+		code int
+		err  error
+	}{
+		{
+			desc: "short circuit",
+			hook: func(_ *http.Request) (*http.Request, error) {
+				return nil, &ShortCircuit{
+					Response: &http.Response{
+						StatusCode: http.StatusUnauthorized,
+						Body:       http.NoBody,
+					},
+				}
+			},
+			code: http.StatusUnauthorized,
+		},
+		{
+			desc: "error",
+			hook: func(_ *http.Request) (*http.Request, error) {
+				return nil, errHook
+			},
+			err: errHook,
+		},
+		{
+			desc: "nil short circuit response",
+			hook: func(_ *http.Request) (*http.Request, error) {
+				return nil, &ShortCircuit{}
+			},
+			err: errNilHookResponse,
+		},
+		{
+			desc: "nil request",
+			hook: func(_ *http.Request) (*http.Request, error) {
+				return nil, nil
+			},
+			err: errNilHookRequest,
+		},
+	}
+
+	for i, tt := range tests {
+		rt := newTestRoundTripper(t, WithRequestHook(tt.hook))
+
+		res, err := rt.RoundTrip(newRequest(t, http.MethodGet, "sftp://127.0.0.1:22/foo"))
+		if want, got := tt.err, err; !errors.Is(got, want) {
+			t.Fatalf("[%02d] test %q, unexpected error: %v != %v",
+				i, tt.desc, want, got)
+		}
+		if err != nil {
+			continue
+		}
+
+		if want, got := tt.code, res.StatusCode; want != got {
+			t.Fatalf("[%02d] test %q, unexpected status code: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package sshttp
+
+import (
+	"time"
+)
+
+// WithIdleTimeout configures a RoundTripper to close connections which have
+// not been used to serve a request for the specified duration.  Connections
+// are never closed while serving a request or streaming a response body,
+// and a new connection is dialed if a closed connection's host is requested
+// again.  By default, connections remain open until the RoundTripper is
+// closed.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.idleTimeout = timeout
+	}
+}
+
+// reapIdle closes and evicts the connection p for host once it has been idle
+// for the configured timeout.  It returns once p is closed.
+func (rt *RoundTripper) reapIdle(host string, p *clientPair) {
+	timeout := rt.opts.idleTimeout
+
+	// Check several times per timeout, so connections are not kept open
+	// for much longer than the timeout
+	t := time.NewTicker(timeout / 4)
+	defer t.Stop()
+
+	seen := p.lastUsed.Load()
+	idleSince := time.Now()
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-t.C:
+			// Any use of the connection restarts the idle period
+			if used := p.lastUsed.Load(); p.inFlight.Load() > 0 || used != seen {
+				seen = used
+				idleSince = now
+				continue
+			}
+			if now.Sub(idleSince) < timeout {
+				continue
+			}
+		}
+
+		// Check again while holding the lock, so the connection cannot be
+		// handed to a new request while it is being evicted
+		rt.mu.Lock()
+		idle := rt.conn[host] == p && p.inFlight.Load() == 0 && p.lastUsed.Load() == seen
+		if idle {
+			delete(rt.conn, host)
+		}
+		rt.mu.Unlock()
+
+		if idle {
+			_ = p.close()
+			return
+		}
+	}
+}
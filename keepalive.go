@@ -0,0 +1,63 @@
+package sshttp
+
+import (
+	"errors"
+	"time"
+)
+
+// errKeepAliveTimeout indicates that a keepalive request was not answered.
+var errKeepAliveTimeout = errors.New("sshttp: keepalive request timed out")
+
+// WithKeepAlive configures a RoundTripper or FileSystem to send a SSH
+// keepalive request over each connection at the specified interval, so that
+// idle connections are not silently dropped by firewalls or servers.  If a
+// keepalive request is not answered within the interval, the connection is
+// closed, and a RoundTripper dials a new connection to the host for its next
+// request.  By default, no keepalive requests are sent.
+func WithKeepAlive(interval time.Duration) Option {
+	return func(o *options) {
+		o.keepAlive = interval
+	}
+}
+
+// keepAlive sends keepalive requests over p's SSH connection at the
+// specified interval until p is closed, or until a request fails.
+func (p *clientPair) keepAlive(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-t.C:
+		}
+
+		// Any reply, even a failure, indicates the connection is alive
+		errC := make(chan error, 1)
+		go func() {
+			_, _, err := p.sshc.SendRequest("keepalive@openssh.com", true, nil)
+			errC <- err
+		}()
+
+		timer := time.NewTimer(interval)
+		var err error
+		select {
+		case <-p.done:
+			timer.Stop()
+			return
+		case err = <-errC:
+		case <-timer.C:
+			err = errKeepAliveTimeout
+		}
+		timer.Stop()
+
+		if err != nil {
+			// Mark the connection broken so it is no longer used, and
+			// close it to unblock any pending requests
+			p.broken.Store(true)
+			_ = p.sshc.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package sshttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A listingEntry is an entry in a JSON directory listing.
+type listingEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modtime"`
+	IsDir   bool      `json:"isDir"`
+}
+
+// directory serves the directory named by r.URL.Path, either as an archive,
+// if one is requested, or as a listing.  Listings are sent as JSON if the
+// request prefers application/json, or as HTML otherwise.
+//
+// Listings may be paginated using the offset and limit query parameters,
+// such as ?offset=100&limit=50, in which case Link headers are added to
+// navigate to the next and previous pages.
+func (rt *RoundTripper) directory(p *clientPair, r *http.Request) (*http.Response, error) {
+	dir := r.URL.Path
+
+	// Both archives and listings may be selected using the Accept header
+	if format := archiveFormat(r); format != "" {
+		res := rt.archive(r.Context(), p, dir, format)
+		addVary(res.Header, "Accept")
+		return res, nil
+	}
+
+	fis, err := rt.readDir(p, dir)
+	if err != nil {
+		return rt.errorResponse(r, err)
+	}
+
+	h := http.Header{}
+	fis, ok := paginate(r.URL, fis, h)
+	if !ok {
+		return rt.httpResponse(http.StatusBadRequest, nil, nil), nil
+	}
+
+	const (
+		typeHTML = "text/html"
+		typeJSON = "application/json"
+	)
+
+	var buf bytes.Buffer
+
+	switch negotiate(r.Header.Get("Accept"), typeHTML, typeJSON) {
+	case typeJSON:
+		entries := make([]listingEntry, 0, len(fis))
+		for _, fi := range fis {
+			entries = append(entries, listingEntry{
+				Name:    fi.Name(),
+				Size:    fi.Size(),
+				ModTime: fi.ModTime().UTC(),
+				IsDir:   fi.IsDir(),
+			})
+		}
+
+		if err := json.NewEncoder(&buf).Encode(entries); err != nil {
+			return nil, err
+		}
+		h.Set("Content-Type", "application/json")
+	default:
+		buf.WriteString("<pre>\n")
+		for _, fi := range fis {
+			name := fi.Name()
+			u := url.URL{Path: path.Join(dir, name)}
+			if fi.IsDir() {
+				name += "/"
+				u.Path += "/"
+			}
+
+			fmt.Fprintf(&buf, "<a href=\"%s\">%s</a>\n", u.String(), html.EscapeString(name))
+		}
+		buf.WriteString("</pre>\n")
+		h.Set("Content-Type", "text/html; charset=utf-8")
+	}
+
+	h.Set("Content-Length", strconv.Itoa(buf.Len()))
+	addVary(h, "Accept")
+	return rt.httpResponse(http.StatusOK, io.NopCloser(&buf), h), nil
+}
+
+// readDir reads the entries of the remote directory dir, sorted by name.
+// Entries which may not be served are omitted.
+func (rt *RoundTripper) readDir(p *clientPair, dir string) ([]os.FileInfo, error) {
+	fis, err := p.readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(byBaseName(fis))
+
+	out := fis[:0]
+	for _, fi := range fis {
+		if rt.opts.checkPath(path.Join(dir, fi.Name())) != nil {
+			continue
+		}
+
+		out = append(out, fi)
+	}
+
+	return out, nil
+}
+
+// paginate returns the page of the sorted entries fis selected by the offset
+// and limit query parameters in u, adding Link headers to h for the next and
+// previous pages.  An offset beyond the end of fis produces an empty page, and
+// a missing or zero limit includes all remaining entries.  If either parameter
+// is invalid, paginate returns false.
+func paginate(u *url.URL, fis []os.FileInfo, h http.Header) ([]os.FileInfo, bool) {
+	q := u.Query()
+	if q.Get("offset") == "" && q.Get("limit") == "" {
+		return fis, true
+	}
+
+	var offset, limit int
+	for _, p := range []struct {
+		key string
+		v   *int
+	}{
+		{key: "offset", v: &offset},
+		{key: "limit", v: &limit},
+	} {
+		s := q.Get(p.key)
+		if s == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		*p.v = n
+	}
+
+	start := offset
+	if start > len(fis) {
+		start = len(fis)
+	}
+	end := len(fis)
+	if limit > 0 && limit < end-start {
+		end = start + limit
+	}
+
+	// Links retain all other query parameters, such as the requested format
+	link := func(offset int, rel string) {
+		q.Set("offset", strconv.Itoa(offset))
+		lu := url.URL{Path: u.Path, RawQuery: q.Encode()}
+		h.Add("Link", fmt.Sprintf("<%s>; rel=%q", lu.String(), rel))
+	}
+	if limit > 0 && end < len(fis) {
+		link(end, "next")
+	}
+	if offset > 0 {
+		prev := start - limit
+		if limit == 0 || prev < 0 {
+			prev = 0
+		}
+		link(prev, "prev")
+	}
+
+	return fis[start:end], true
+}
+
+// addVary adds each of the request header names in keys to the Vary header
+// in h, merging them with any names already present, so that caches can
+// distinguish between responses which were negotiated using those headers.
+func addVary(h http.Header, keys ...string) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" || seen[name] {
+				continue
+			}
+
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range keys {
+		name = http.CanonicalHeaderKey(name)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	h.Set("Vary", strings.Join(names, ", "))
+}
+
+// negotiate selects the media type from offers which is most preferred by
+// the input Accept header value.  Ties are broken by the order of offers.
+// If accept is empty, the first offer is returned.
+func negotiate(accept string, offers ...string) string {
+	if accept == "" {
+		return offers[0]
+	}
+
+	var (
+		best  string
+		bestQ float64
+	)
+	for _, offer := range offers {
+		if q := acceptQuality(accept, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	if best == "" {
+		return offers[0]
+	}
+
+	return best
+}
+
+// acceptQuality returns the quality value which the Accept header value
+// accept assigns to the media type mt, using the most specific matching
+// media range.
+func acceptQuality(accept string, mt string) float64 {
+	var (
+		q           float64
+		specificity = -1
+	)
+
+	for _, a := range strings.Split(accept, ",") {
+		rng, params, err := mime.ParseMediaType(strings.TrimSpace(a))
+		if err != nil {
+			continue
+		}
+
+		var spec int
+		switch {
+		case rng == mt:
+			spec = 2
+		case strings.HasSuffix(rng, "/*") && strings.HasPrefix(mt, strings.TrimSuffix(rng, "*")):
+			spec = 1
+		case rng == "*/*":
+			spec = 0
+		default:
+			continue
+		}
+		if spec < specificity {
+			continue
+		}
+
+		aq := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				aq = f
+			}
+		}
+
+		q, specificity = aq, spec
+	}
+
+	return q
+}
@@ -0,0 +1,343 @@
+package sshttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFileInfo is an os.FileInfo with only a name.
+type testFileInfo string
+
+func (fi testFileInfo) Name() string    { return string(fi) }
+func (testFileInfo) Size() int64        { return 0 }
+func (testFileInfo) Mode() os.FileMode  { return 0 }
+func (testFileInfo) ModTime() time.Time { return time.Time{} }
+func (testFileInfo) IsDir() bool        { return false }
+func (testFileInfo) Sys() interface{}   { return nil }
+
+func TestPaginate(t *testing.T) {
+	fis := []os.FileInfo{
+		testFileInfo("a"),
+		testFileInfo("b"),
+		testFileInfo("c"),
+		testFileInfo("d"),
+		testFileInfo("e"),
+	}
+
+	var tests = []struct {
+		desc  string
+		query string
+		names []string
+		links []string
+		ok    bool
+	}{
+		{
+			desc:  "no pagination",
+			names: []string{"a", "b", "c", "d", "e"},
+			ok:    true,
+		},
+		{
+			desc:  "first page",
+			query: "limit=2",
+			names: []string{"a", "b"},
+			links: []string{`</dir/?limit=2&offset=2>; rel="next"`},
+			ok:    true,
+		},
+		{
+			desc:  "middle page",
+			query: "offset=2&limit=2",
+			names: []string{"c", "d"},
+			links: []string{
+				`</dir/?limit=2&offset=4>; rel="next"`,
+				`</dir/?limit=2&offset=0>; rel="prev"`,
+			},
+			ok: true,
+		},
+		{
+			desc:  "limit larger than remaining",
+			query: "offset=3&limit=10",
+			names: []string{"d", "e"},
+			links: []string{`</dir/?limit=10&offset=0>; rel="prev"`},
+			ok:    true,
+		},
+		{
+			desc:  "offset beyond length",
+			query: "offset=10&limit=2",
+			names: []string{},
+			links: []string{`</dir/?limit=2&offset=3>; rel="prev"`},
+			ok:    true,
+		},
+		{
+			desc:  "offset without limit",
+			query: "offset=4&format=json",
+			names: []string{"e"},
+			links: []string{`</dir/?format=json&offset=0>; rel="prev"`},
+			ok:    true,
+		},
+		{
+			desc:  "negative offset",
+			query: "offset=-1",
+		},
+		{
+			desc:  "malformed limit",
+			query: "limit=foo",
+		},
+	}
+
+	for i, tt := range tests {
+		u := &url.URL{Path: "/dir/", RawQuery: tt.query}
+		h := http.Header{}
+
+		page, ok := paginate(u, fis, h)
+		if want, got := tt.ok, ok; want != got {
+			t.Fatalf("[%02d] test %q, unexpected ok: %v != %v",
+				i, tt.desc, want, got)
+		}
+		if !ok {
+			continue
+		}
+
+		names := make([]string, 0, len(page))
+		for _, fi := range page {
+			names = append(names, fi.Name())
+		}
+		if want, got := tt.names, names; !reflect.DeepEqual(want, got) {
+			t.Fatalf("[%02d] test %q, unexpected names: %v != %v",
+				i, tt.desc, want, got)
+		}
+		if want, got := tt.links, h.Values("Link"); !reflect.DeepEqual(want, got) {
+			t.Fatalf("[%02d] test %q, unexpected links:\n- want: %v\n-  got: %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{"text/html", "application/json"}
+
+	var tests = []struct {
+		accept string
+		want   string
+	}{
+		{accept: "", want: "text/html"},
+		{accept: "application/json", want: "application/json"},
+		{accept: "text/html", want: "text/html"},
+		{accept: "*/*", want: "text/html"},
+		{accept: "application/*", want: "application/json"},
+		{accept: "text/html;q=0.5, application/json", want: "application/json"},
+		{accept: "application/json;q=0.1, text/*;q=0.2", want: "text/html"},
+		{accept: "*/*;q=0.9, text/html;q=0", want: "application/json"},
+		{accept: "image/png", want: "text/html"},
+		{accept: "not a media type", want: "text/html"},
+	}
+
+	for i, tt := range tests {
+		if want, got := tt.want, negotiate(tt.accept, offers...); want != got {
+			t.Fatalf("[%02d] accept %q, unexpected media type: %q != %q",
+				i, tt.accept, want, got)
+		}
+	}
+}
+
+func TestAddVary(t *testing.T) {
+	var tests = []struct {
+		desc string
+		vary []string
+		keys []string
+		want string
+	}{
+		{
+			desc: "empty",
+			keys: []string{"accept"},
+			want: "Accept",
+		},
+		{
+			desc: "merged",
+			vary: []string{"Origin"},
+			keys: []string{"Accept"},
+			want: "Origin, Accept",
+		},
+		{
+			desc: "duplicates",
+			vary: []string{"accept, Origin", "Accept"},
+			keys: []string{"Accept", "origin"},
+			want: "Accept, Origin",
+		},
+	}
+
+	for i, tt := range tests {
+		h := http.Header{}
+		for _, v := range tt.vary {
+			h.Add("Vary", v)
+		}
+
+		addVary(h, tt.keys...)
+		if want, got := []string{tt.want}, h.Values("Vary"); !reflect.DeepEqual(want, got) {
+			t.Fatalf("[%02d] test %q, unexpected Vary: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestRoundTripperDirectoryListing(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "dir/b.txt", []byte("hello"))
+	s.writeFile(t, "dir/a/c.txt", nil)
+
+	var tests = []struct {
+		desc   string
+		accept string
+		cType  string
+	}{
+		{
+			desc:  "no Accept",
+			cType: "text/html; charset=utf-8",
+		},
+		{
+			desc:   "HTML",
+			accept: "text/html",
+			cType:  "text/html; charset=utf-8",
+		},
+		{
+			desc:   "JSON",
+			accept: "application/json",
+			cType:  "application/json",
+		},
+		{
+			desc:   "prefers JSON",
+			accept: "text/html;q=0.5, application/json",
+			cType:  "application/json",
+		},
+	}
+
+	rt := newTestRoundTripper(t)
+
+	for i, tt := range tests {
+		r := newRequest(t, http.MethodGet, s.url("dir")+"/")
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		res, body := do(t, rt, r)
+
+		if want, got := http.StatusOK, res.StatusCode; want != got {
+			t.Fatalf("[%02d] test %q, unexpected status code: %v != %v",
+				i, tt.desc, want, got)
+		}
+		if want, got := tt.cType, res.Header.Get("Content-Type"); want != got {
+			t.Fatalf("[%02d] test %q, unexpected Content-Type: %q != %q",
+				i, tt.desc, want, got)
+		}
+		if want, got := "Accept", res.Header.Get("Vary"); want != got {
+			t.Fatalf("[%02d] test %q, unexpected Vary: %q != %q",
+				i, tt.desc, want, got)
+		}
+
+		if tt.cType != "application/json" {
+			for _, link := range []string{`<a href="` + s.path("dir/a") + `/">a/</a>`, "b.txt</a>"} {
+				if !strings.Contains(string(body), link) {
+					t.Fatalf("[%02d] test %q, listing does not contain %q:\n%s",
+						i, tt.desc, link, body)
+				}
+			}
+
+			continue
+		}
+
+		var entries []listingEntry
+		if err := json.Unmarshal(body, &entries); err != nil {
+			t.Fatalf("[%02d] test %q, failed to unmarshal listing: %v",
+				i, tt.desc, err)
+		}
+		// Modification times and the sizes of directories vary
+		for i := range entries {
+			entries[i].ModTime = time.Time{}
+			if entries[i].IsDir {
+				entries[i].Size = 0
+			}
+		}
+
+		want := []listingEntry{
+			{Name: "a", IsDir: true},
+			{Name: "b.txt", Size: 5},
+		}
+		if got := entries; !reflect.DeepEqual(want, got) {
+			t.Fatalf("[%02d] test %q, unexpected entries: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestRoundTripperDirectoryPagination(t *testing.T) {
+	s := newTestServer(t)
+	for _, name := range []string{"a", "b", "c"} {
+		s.writeFile(t, "dir/"+name, nil)
+	}
+
+	var tests = []struct {
+		desc  string
+		query string
+		code  int
+		names []string
+	}{
+		{
+			desc:  "first page",
+			query: "?limit=2",
+			code:  http.StatusOK,
+			names: []string{"a", "b"},
+		},
+		{
+			desc:  "limit larger than remaining",
+			query: "?offset=1&limit=10",
+			code:  http.StatusOK,
+			names: []string{"b", "c"},
+		},
+		{
+			desc:  "offset beyond length",
+			query: "?offset=10",
+			code:  http.StatusOK,
+			names: []string{},
+		},
+		{
+			desc:  "invalid",
+			query: "?limit=-1",
+			code:  http.StatusBadRequest,
+		},
+	}
+
+	rt := newTestRoundTripper(t)
+
+	for i, tt := range tests {
+		r := newRequest(t, http.MethodGet, s.url("dir")+"/"+tt.query)
+		r.Header.Set("Accept", "application/json")
+		res, body := do(t, rt, r)
+
+		if want, got := tt.code, res.StatusCode; want != got {
+			t.Fatalf("[%02d] test %q, unexpected status code: %v != %v",
+				i, tt.desc, want, got)
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+
+		var entries []listingEntry
+		if err := json.Unmarshal(body, &entries); err != nil {
+			t.Fatalf("[%02d] test %q, failed to unmarshal listing: %v",
+				i, tt.desc, err)
+		}
+
+		names := make([]string, 0, len(entries))
+		for _, e := range entries {
+			names = append(names, e.Name)
+		}
+		if want, got := tt.names, names; !reflect.DeepEqual(want, got) {
+			t.Fatalf("[%02d] test %q, unexpected names: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package sshttp
+
+import (
+	"errors"
+	"os"
+	"strconv"
+)
+
+// WithDefaultMode configures a RoundTripper or FileSystem to set the
+// permissions of the files it creates to mode, rather than leaving them to
+// the remote host's umask.  This applies to files created by uploads, and to
+// files and directories created using FileSystem.WebDAV.  Directories also
+// receive execute permission for each class of user which mode permits to
+// read, so that 0640 creates directories with mode 0750.  Only the
+// permission bits of mode are used.
+//
+// Files which already exist keep their permissions when they are replaced.
+// The mode of the files created by an individual upload may be set using the
+// X-File-Mode header, as an octal number such as 0640, which takes
+// precedence over this option.
+func WithDefaultMode(mode os.FileMode) Option {
+	return func(o *options) {
+		mode = mode.Perm()
+		o.defaultMode = &mode
+	}
+}
+
+// dirMode returns the mode for a directory created using the file mode
+// mode, which adds execute permission wherever mode grants read permission.
+func dirMode(mode os.FileMode) os.FileMode {
+	return mode | (mode&0444)>>2
+}
+
+// errInvalidMode is returned when a file mode cannot be parsed.
+var errInvalidMode = errors.New("sshttp: invalid file mode")
+
+// parseMode parses the octal permission bits in s, such as those in the
+// X-File-Mode header.
+func parseMode(s string) (os.FileMode, error) {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n > 0777 {
+		return 0, errInvalidMode
+	}
+
+	return os.FileMode(n), nil
+}
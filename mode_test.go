@@ -0,0 +1,70 @@
+package sshttp
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseMode(t *testing.T) {
+	var tests = []struct {
+		desc string
+		s    string
+		mode os.FileMode
+		ok   bool
+	}{
+		{
+			desc: "leading zero",
+			s:    "0640",
+			mode: 0640,
+			ok:   true,
+		},
+		{
+			desc: "no leading zero",
+			s:    "600",
+			mode: 0600,
+			ok:   true,
+		},
+		{
+			desc: "not octal",
+			s:    "0689",
+		},
+		{
+			desc: "too large",
+			s:    "4755",
+		},
+		{
+			desc: "symbolic",
+			s:    "rw-r-----",
+		},
+	}
+
+	for i, tt := range tests {
+		mode, err := parseMode(tt.s)
+		if want, got := tt.ok, err == nil; want != got {
+			t.Fatalf("[%02d] test %q, unexpected error: %v", i, tt.desc, err)
+		}
+		if want, got := tt.mode, mode; want != got {
+			t.Fatalf("[%02d] test %q, unexpected mode: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestDirMode(t *testing.T) {
+	var tests = []struct {
+		mode os.FileMode
+		want os.FileMode
+	}{
+		{mode: 0600, want: 0700},
+		{mode: 0640, want: 0750},
+		{mode: 0644, want: 0755},
+		{mode: 0200, want: 0200},
+	}
+
+	for i, tt := range tests {
+		if want, got := tt.want, dirMode(tt.mode); want != got {
+			t.Fatalf("[%02d] unexpected directory mode for %v: %v != %v",
+				i, tt.mode, want, got)
+		}
+	}
+}
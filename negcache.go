@@ -0,0 +1,80 @@
+package sshttp
+
+import (
+	"sync"
+	"time"
+)
+
+// WithNegativeCacheTTL configures a RoundTripper to remember, for up to ttl,
+// which paths on each connection were found not to exist, and to respond to
+// further requests for those paths with 404 Not Found without contacting the
+// server.  This reduces the load caused by repeated requests for missing
+// paths, but a file created during the TTL is not served until it expires.
+// A TTL of zero or less disables negative caching, which is the default.
+func WithNegativeCacheTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.negativeCacheTTL = ttl
+	}
+}
+
+// A negativeCache remembers paths which do not exist for a fixed TTL.
+type negativeCache struct {
+	ttl time.Duration
+
+	mu sync.Mutex
+	m  map[string]time.Time
+}
+
+// newNegativeCache creates a negativeCache using the specified TTL.  If the
+// TTL is zero or less, it returns nil, which disables caching.
+func newNegativeCache(ttl time.Duration) *negativeCache {
+	if ttl <= 0 {
+		return nil
+	}
+
+	return &negativeCache{
+		ttl: ttl,
+		m:   make(map[string]time.Time),
+	}
+}
+
+// missing reports whether name is known not to exist.
+func (c *negativeCache) missing(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires, ok := c.m[name]
+	if !ok {
+		return false
+	}
+	if time.Now().After(expires) {
+		delete(c.m, name)
+		return false
+	}
+
+	return true
+}
+
+// add records that name does not exist, and evicts any expired entries.
+func (c *negativeCache) add(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, expires := range c.m {
+		if now.After(expires) {
+			delete(c.m, k)
+		}
+	}
+
+	c.m[name] = now.Add(c.ttl)
+}
+
+// remove forgets that name does not exist, and should be called whenever
+// name is created.
+func (c *negativeCache) remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.m, name)
+}
@@ -0,0 +1,572 @@
+package sshttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// defaultSniffLength is the number of bytes read to detect a file's
+	// content type if none is specified using WithSniffLength.
+	defaultSniffLength = 512
+
+	// defaultContentType is the content type used for files whose type
+	// cannot be determined when content type sniffing is disabled.
+	defaultContentType = "application/octet-stream"
+
+	// defaultServerHeader is the value of the Server header if none is
+	// specified using WithServerHeader.
+	defaultServerHeader = "github.com/mdlayher/sshttp"
+)
+
+// An Option is a functional option which can be used to configure a
+// RoundTripper or FileSystem.  Options which only make sense for one of
+// the two types are ignored by the other.
+type Option func(*options)
+
+// options contains configuration which is shared by RoundTripper and
+// FileSystem, and is populated using Option values.
+type options struct {
+	// Deny access to, or hide, any path with an element beginning with a
+	// dot
+	denyDotfiles bool
+	hideDotfiles bool
+
+	// Rules which permit or forbid access to paths; if any allow rules
+	// are present, a path must match at least one of them
+	allow []pathRule
+	deny  []pathRule
+
+	// Maximum size of a served file, and whether or not larger files
+	// should be truncated instead of refused
+	maxSize  int64
+	truncate bool
+
+	// Retry behavior for transient errors
+	maxRetries int
+	backoff    time.Duration
+
+	// Number of bytes read to detect a file's content type
+	sniffLen int
+
+	// Timeout applied to requests which carry no deadline
+	requestTimeout time.Duration
+
+	// Custom content type detection
+	mimeResolver func(name string, head []byte) string
+
+	// Value of the Server header, if set; an empty string omits it
+	serverHeader *string
+
+	// Rules which apply caching headers to successful responses
+	cache []cacheRule
+
+	// Backup hosts tried in order when a primary host fails
+	failover map[string][]string
+
+	// Whether files should always be sent as attachments
+	forceDownload bool
+
+	// Path which runs commands using POST, if set
+	execPath string
+
+	// Whether files may be uploaded using POST, and whether uploads may
+	// replace existing files
+	uploads         bool
+	uploadOverwrite bool
+
+	// Whether files may be appended to using PATCH
+	appends bool
+
+	// Permissions for created files, if set
+	defaultMode *os.FileMode
+
+	// Throughput limits for each response body, and for all of them
+	rateLimit     int
+	globalLimiter *rate.Limiter
+
+	// Establishes network connections for SSH, if set
+	dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Digest algorithm for served files, and the maximum size of files
+	// which are digested before their response is sent
+	digest          string
+	eagerDigestSize int64
+
+	// Lifetime of cached directory contents
+	dirCacheTTL time.Duration
+
+	// Whether file contents should not be read to detect content types
+	noSniff bool
+
+	// Whether or not responses other than 200 OK may keep the connection
+	// open
+	noAutoClose bool
+
+	// Maximum number of open files cached per connection
+	handleCacheSize int
+
+	// Lifetime of cached missing paths
+	negativeCacheTTL time.Duration
+
+	// Maximum number of redirects between hosts to follow
+	maxRedirects int
+
+	// Maximum number of symbolic links to follow for a path
+	symlinkHops int
+
+	// Content type for files which are not sniffed
+	defaultType string
+
+	// Pool of buffers used to copy files, if configured
+	buffers *sync.Pool
+
+	// Headers added to every response
+	headers http.Header
+
+	// Origins permitted to make cross-origin requests
+	corsOrigins []string
+
+	// Name of the SSH subsystem which provides SFTP, if not the default
+	subsystem string
+
+	// Options applied to each SFTP client
+	sftpOptions []sftp.ClientOption
+
+	// Interval between SSH keepalive requests, if enabled
+	keepAlive time.Duration
+
+	// Duration after which unused connections are closed, if enabled
+	idleTimeout time.Duration
+
+	// Duration for which failed hosts in a group are skipped, and whether
+	// requests are distributed across a group's hosts
+	cooldown   time.Duration
+	roundRobin bool
+
+	// Interval at which followed files are polled, if enabled
+	followInterval time.Duration
+
+	// Whether or not to expose file permissions and ownership
+	fileInfoHeaders bool
+
+	// Whether or not to send metadata about transfers in trailers
+	trailers bool
+
+	// Host key algorithms accepted from servers, if not the defaults
+	hostKeyAlgorithms []string
+
+	// Whether or not missing files are reported as errors
+	notFoundError bool
+
+	// Style of paths used by remote hosts
+	pathStyle PathStyle
+
+	// Hooks called before requests are served, and before responses are
+	// returned
+	requestHooks  []func(r *http.Request) (*http.Request, error)
+	responseHooks []func(res *http.Response)
+}
+
+// A pathRule reports whether or not a cleaned, rooted path matches a rule.
+type pathRule func(p string) bool
+
+// newOptions applies each Option to a new options struct, and returns it.
+func newOptions(opts []Option) options {
+	var o options
+	for _, fn := range opts {
+		fn(&o)
+	}
+
+	return o
+}
+
+// WithDenyDotfiles configures whether or not a RoundTripper or FileSystem
+// should refuse to serve paths which contain an element beginning with a
+// dot, such as .git or .ssh/id_rsa.  Denied paths are reported as if they
+// do not exist, and are omitted from directory listings, including those
+// returned by File.Readdir.  By default, dotfiles are served.
+func WithDenyDotfiles(deny bool) Option {
+	return func(o *options) {
+		o.denyDotfiles = deny
+	}
+}
+
+// WithHiddenDotfiles configures whether or not a FileSystem or RoundTripper
+// should hide paths which contain an element beginning with a dot, such as
+// .git or .env, when serving a public directory.  Hidden entries are omitted
+// from the results of File.Readdir and from directory listings, and opening
+// them returns an error satisfying os.IsNotExist, so that http.FileServer
+// responds with 404 Not Found.  Dotfiles are hidden if either this option or
+// WithDenyDotfiles is enabled.  By default, dotfiles are shown.
+func WithHiddenDotfiles(hide bool) Option {
+	return func(o *options) {
+		o.hideDotfiles = hide
+	}
+}
+
+// WithAllowGlobs configures a RoundTripper or FileSystem to only serve paths
+// which match at least one of the specified path.Match patterns.  Paths
+// which do not match any allow rule are reported as if they do not exist.
+//
+// Patterns are matched against the cleaned path, relative to the root of a
+// FileSystem, with a leading slash, such as /pub/*.txt.  A malformed pattern
+// never matches.
+func WithAllowGlobs(patterns ...string) Option {
+	return func(o *options) {
+		for _, p := range patterns {
+			o.allow = append(o.allow, globRule(p, false))
+		}
+	}
+}
+
+// WithDenyGlobs configures a RoundTripper or FileSystem to refuse to serve
+// paths which match any of the specified path.Match patterns.  Denied paths
+// are reported as forbidden.  Deny rules take precedence over allow rules.
+//
+// Patterns are matched in the same way as with WithAllowGlobs, except that
+// a malformed pattern always matches.
+func WithDenyGlobs(patterns ...string) Option {
+	return func(o *options) {
+		for _, p := range patterns {
+			o.deny = append(o.deny, globRule(p, true))
+		}
+	}
+}
+
+// WithAllowRegexps is like WithAllowGlobs, but paths are matched using
+// regular expressions.
+func WithAllowRegexps(res ...*regexp.Regexp) Option {
+	return func(o *options) {
+		for _, re := range res {
+			o.allow = append(o.allow, re.MatchString)
+		}
+	}
+}
+
+// WithDenyRegexps is like WithDenyGlobs, but paths are matched using
+// regular expressions.
+func WithDenyRegexps(res ...*regexp.Regexp) Option {
+	return func(o *options) {
+		for _, re := range res {
+			o.deny = append(o.deny, re.MatchString)
+		}
+	}
+}
+
+// WithMaxFileSize configures the maximum size in bytes of a file which may
+// be served by a RoundTripper or FileSystem.  A size of zero or less
+// disables the limit, which is the default.
+//
+// If truncate is false, a RoundTripper responds to requests for larger files
+// with HTTP 413, and a FileSystem returns ErrFileTooLarge when opening them.
+// If truncate is true, a RoundTripper serves only the first size bytes of
+// larger files, and sets the X-Original-Content-Length header to the
+// file's actual size.  Byte ranges may only be requested within the first
+// size bytes, and the Content-Range header of a partial response reports
+// the actual size of the file.  Digests set by WithDigest are omitted for
+// truncated files, since they would not describe the entire file.
+// FileSystem does not support truncation, and always returns
+// ErrFileTooLarge for larger files.
+func WithMaxFileSize(size int64, truncate bool) Option {
+	return func(o *options) {
+		o.maxSize = size
+		o.truncate = truncate
+	}
+}
+
+// WithSniffLength configures the number of bytes a RoundTripper reads from
+// the beginning of a file to detect its content type, when the type cannot
+// be determined using its extension, or when a resolver is set using
+// WithMIMEResolver.  If not set, 512 bytes are read, which is the amount
+// consumed by http.DetectContentType.
+func WithSniffLength(n int) Option {
+	return func(o *options) {
+		o.sniffLen = n
+	}
+}
+
+// sniffLength returns the configured sniff length, or the default.
+func (o *options) sniffLength() int {
+	if o.sniffLen <= 0 {
+		return defaultSniffLength
+	}
+
+	return o.sniffLen
+}
+
+// WithContentTypeSniffing configures whether or not a RoundTripper reads the
+// beginning of a file to detect its content type when the type cannot be
+// determined using its extension.  When disabled, such files are served
+// using the default content type set by WithDefaultContentType, avoiding an
+// extra read over SFTP.  Sniffing is enabled by default.
+func WithContentTypeSniffing(enabled bool) Option {
+	return func(o *options) {
+		o.noSniff = !enabled
+	}
+}
+
+// WithAutoClose configures whether or not a RoundTripper sets the Connection
+// header to close on responses with a status other than 200 OK, such as 404
+// Not Found, so that clients do not reuse the connection.  Disabling this
+// permits connection reuse by clients which make many requests for missing
+// files, for example.  304 Not Modified responses, which have no body, never
+// close the connection.  Automatic closing is enabled by default.
+func WithAutoClose(enabled bool) Option {
+	return func(o *options) {
+		o.noAutoClose = !enabled
+	}
+}
+
+// WithDefaultContentType configures the content type used by a RoundTripper
+// for files whose type cannot be determined using their extension, when
+// content type sniffing is disabled using WithContentTypeSniffing.  The
+// default is application/octet-stream.
+func WithDefaultContentType(cType string) Option {
+	return func(o *options) {
+		o.defaultType = cType
+	}
+}
+
+// contentType returns the configured default content type, or the
+// package default if none is set.
+func (o *options) contentType() string {
+	if o.defaultType == "" {
+		return defaultContentType
+	}
+
+	return o.defaultType
+}
+
+// WithMIMEResolver configures a RoundTripper to determine the content type
+// of each file it serves using fn, which is passed the file's base name and
+// the beginning of its contents, as configured by WithSniffLength.  If fn
+// returns an empty string, the content type is determined using the file's
+// extension or contents, as if no resolver was set.
+func WithMIMEResolver(fn func(name string, head []byte) string) Option {
+	return func(o *options) {
+		o.mimeResolver = fn
+	}
+}
+
+// WithRequestTimeout configures a RoundTripper to apply a timeout to each
+// request which does not already carry a deadline in its context.  The
+// timeout covers opening the remote file and streaming the response body;
+// if it is exceeded, RoundTrip returns context.DeadlineExceeded, or reading
+// the response body returns the same error.  By default, no timeout is
+// applied.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.requestTimeout = d
+	}
+}
+
+// WithHostKeyAlgorithms configures the host key algorithms which a
+// RoundTripper or FileSystem accepts from SSH servers, in order of
+// preference, such as ssh.KeyAlgoRSA for legacy servers which only offer
+// ssh-rsa host keys.  The algorithms replace the HostKeyAlgorithms of any SSH
+// client configuration used to dial a host.
+//
+// Algorithms such as ssh-rsa, which uses SHA-1 signatures, are disabled by
+// default because they are considered weak, and enabling them makes it
+// easier for an attacker to impersonate a server.  They should only be
+// enabled for servers which support nothing stronger.
+func WithHostKeyAlgorithms(algos ...string) Option {
+	return func(o *options) {
+		o.hostKeyAlgorithms = algos
+	}
+}
+
+// WithSubsystem configures the name of the SSH subsystem requested to access
+// SFTP on a remote host, for servers which provide SFTP using a subsystem
+// other than the default, "sftp".
+func WithSubsystem(name string) Option {
+	return func(o *options) {
+		o.subsystem = name
+	}
+}
+
+// WithSFTPOptions configures the options applied to each SFTP client created
+// for a remote host, such as sftp.MaxPacket, sftp.UseConcurrentReads, and
+// sftp.MaxConcurrentRequestsPerFile, which may be used to tune throughput for
+// a particular network or server.
+func WithSFTPOptions(opts ...sftp.ClientOption) Option {
+	return func(o *options) {
+		o.sftpOptions = opts
+	}
+}
+
+// WithHeaders configures a RoundTripper to add the headers in h to every
+// response it produces, such as X-Frame-Options or Access-Control-Allow-Origin.
+// Headers in h do not replace any headers set for an individual response,
+// such as a file's Content-Type, but do replace default values which would
+// otherwise be applied, such as the Content-Type of error responses.  The
+// Server header may only be configured using WithServerHeader, and is
+// ignored if present in h.
+func WithHeaders(h http.Header) Option {
+	return func(o *options) {
+		o.headers = h.Clone()
+	}
+}
+
+// WithBufferSize configures the size of the buffer used by a RoundTripper
+// to copy each file from a remote host to a response body.  Larger buffers
+// reduce the number of round trips needed to transfer a file over high
+// latency links.  Buffers are pooled and reused between requests.  The
+// default size is 32KiB.
+func WithBufferSize(size int) Option {
+	return func(o *options) {
+		if size <= 0 {
+			o.buffers = nil
+			return
+		}
+
+		o.buffers = &sync.Pool{
+			New: func() interface{} {
+				b := make([]byte, size)
+				return &b
+			},
+		}
+	}
+}
+
+// WithServerHeader configures the value of the Server header sent in each
+// response generated by a RoundTripper.  An empty string omits the header
+// entirely.  If not set, "github.com/mdlayher/sshttp" is used.
+func WithServerHeader(server string) Option {
+	return func(o *options) {
+		o.serverHeader = &server
+	}
+}
+
+// server returns the configured Server header value, or the default.
+func (o *options) server() string {
+	if o.serverHeader == nil {
+		return defaultServerHeader
+	}
+
+	return *o.serverHeader
+}
+
+// WithFailover configures a RoundTripper to try each of the backup hosts in
+// order when a request for primary fails due to a connection-level error,
+// such as the primary host being unreachable.  HTTP error responses, such as
+// 404 Not Found, never cause a backup host to be tried.  Only GET and OPTIONS
+// requests fail over; requests which may modify the remote host, such as
+// PATCH, are never repeated on a backup host.
+//
+// Backup hosts are dialed using the default configuration, unless they have
+// been configured using the RoundTripper's Dial method.  Failover can only
+// help if the requested file also exists on a backup host.
+func WithFailover(primary string, backups ...string) Option {
+	return func(o *options) {
+		if o.failover == nil {
+			o.failover = make(map[string][]string)
+		}
+
+		o.failover[primary] = backups
+	}
+}
+
+// WithFileInfoHeaders configures whether or not a RoundTripper exposes the
+// permissions and ownership of each file it serves using response headers.
+// The X-File-Mode header contains the file's permission bits in octal, such
+// as 0644, and the X-File-Uid and X-File-Gid headers contain the numeric user
+// and group IDs of the file's owner, if reported by the remote host.  By
+// default, these headers are not sent.
+func WithFileInfoHeaders(enabled bool) Option {
+	return func(o *options) {
+		o.fileInfoHeaders = enabled
+	}
+}
+
+// WithNotFoundError configures how a RoundTripper reports requests for files
+// which do not exist, or which are hidden by rules such as WithDenyDotfiles.
+// By default, RoundTrip returns a 404 Not Found response.  If enabled,
+// RoundTrip instead returns an error which satisfies errors.Is(err,
+// os.ErrNotExist), so that callers can distinguish missing files from other
+// failures without inspecting responses.
+//
+// FileSystem always reports missing files using errors which satisfy
+// errors.Is(err, os.ErrNotExist), which http.FileServer reports as 404 Not
+// Found.
+func WithNotFoundError(enabled bool) Option {
+	return func(o *options) {
+		o.notFoundError = enabled
+	}
+}
+
+// WithForceDownload configures a RoundTripper to send a Content-Disposition
+// header with each file it serves, so that clients such as web browsers
+// download the file instead of displaying it.  The file's name is encoded
+// using RFC 2231 if it contains non-ASCII characters.
+//
+// Regardless of this option, a download may be requested for an individual
+// file using the download query parameter, such as ?download=1.
+func WithForceDownload(force bool) Option {
+	return func(o *options) {
+		o.forceDownload = force
+	}
+}
+
+// WithDialer configures a RoundTripper or FileSystem to establish the network
+// connection for each SSH client using dial, such as to connect through a
+// SOCKS proxy.  The SSH handshake is then performed over the returned
+// connection.  By default, a net.Dialer is used, with the timeout from the
+// ssh.ClientConfig.
+func WithDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return func(o *options) {
+		o.dialer = dial
+	}
+}
+
+// globRule creates a pathRule using a path.Match pattern.  If the pattern
+// is malformed, the rule returns onErr, so that rules fail closed.
+func globRule(pattern string, onErr bool) pathRule {
+	return func(p string) bool {
+		ok, err := path.Match(pattern, p)
+		if err != nil {
+			return onErr
+		}
+
+		return ok
+	}
+}
+
+// checkPath determines if the input path may be served using the current
+// options.  It returns os.ErrNotExist for paths which should be hidden,
+// os.ErrPermission for paths which are explicitly denied, or nil if the
+// path may be served.
+func (o *options) checkPath(p string) error {
+	p = o.cleanPath(p)
+
+	if (o.denyDotfiles || o.hideDotfiles) && hasDotfile(p) {
+		return os.ErrNotExist
+	}
+
+	for _, fn := range o.deny {
+		if fn(p) {
+			return os.ErrPermission
+		}
+	}
+
+	if len(o.allow) == 0 {
+		return nil
+	}
+	for _, fn := range o.allow {
+		if fn(p) {
+			return nil
+		}
+	}
+
+	return os.ErrNotExist
+}
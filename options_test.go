@@ -0,0 +1,98 @@
+package sshttp
+
+import (
+	"os"
+	"regexp"
+	"testing"
+)
+
+func TestOptionsCheckPath(t *testing.T) {
+	var tests = []struct {
+		desc string
+		opts []Option
+		path string
+		err  error
+	}{
+		{
+			desc: "no rules",
+			path: "/foo/.bar",
+		},
+		{
+			desc: "dotfile",
+			opts: []Option{WithDenyDotfiles(true)},
+			path: "/home/foo/.ssh/id_ed25519",
+			err:  os.ErrNotExist,
+		},
+		{
+			desc: "dotfile after cleaning",
+			opts: []Option{WithDenyDotfiles(true)},
+			path: "foo/../.bar",
+			err:  os.ErrNotExist,
+		},
+		{
+			desc: "not a dotfile",
+			opts: []Option{WithDenyDotfiles(true)},
+			path: "/foo/bar.txt",
+		},
+		{
+			desc: "denied glob",
+			opts: []Option{WithDenyGlobs("/secret/*")},
+			path: "/secret/key",
+			err:  os.ErrPermission,
+		},
+		{
+			desc: "malformed deny glob",
+			opts: []Option{WithDenyGlobs("[")},
+			path: "/foo",
+			err:  os.ErrPermission,
+		},
+		{
+			desc: "allowed glob",
+			opts: []Option{WithAllowGlobs("/pub/*")},
+			path: "/pub/file",
+		},
+		{
+			desc: "not allowed glob",
+			opts: []Option{WithAllowGlobs("/pub/*")},
+			path: "/priv/file",
+			err:  os.ErrNotExist,
+		},
+		{
+			desc: "deny takes precedence",
+			opts: []Option{
+				WithAllowGlobs("/pub/*"),
+				WithDenyGlobs("/pub/*.key"),
+			},
+			path: "/pub/server.key",
+			err:  os.ErrPermission,
+		},
+		{
+			desc: "allowed regexp",
+			opts: []Option{WithAllowRegexps(regexp.MustCompile(`\.log$`))},
+			path: "/var/log/syslog.log",
+		},
+		{
+			desc: "denied regexp",
+			opts: []Option{WithDenyRegexps(regexp.MustCompile(`^/etc/`))},
+			path: "/etc/shadow",
+			err:  os.ErrPermission,
+		},
+		{
+			desc: "Windows separators",
+			opts: []Option{
+				WithPathStyle(PathWindows),
+				WithDenyGlobs("/secret/*"),
+			},
+			path: `foo\..\secret\key`,
+			err:  os.ErrPermission,
+		},
+	}
+
+	for i, tt := range tests {
+		o := newOptions(tt.opts)
+		if want, got := tt.err, o.checkPath(tt.path); want != got {
+			t.Fatalf("[%02d] test %q, unexpected error: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package sshttp
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// WithAppends configures a RoundTripper to append the body of PATCH requests
+// to existing files in the remote filesystem, responding with each file's new
+// total size in bytes.  Files are never created by a PATCH request.  By
+// default, PATCH requests are refused with 405 Method Not Allowed.
+func WithAppends(enabled bool) Option {
+	return func(o *options) {
+		o.appends = enabled
+	}
+}
+
+// patch appends the request body to an existing remote file, and responds
+// with the file's new total size in bytes.  If the file does not exist, it
+// responds with 404 Not Found, and if permission to write the file is denied,
+// it responds with 403 Forbidden.
+//
+// If the request carries a X-Last-Modified header in HTTP date format, the
+// file's access and modification times are set to its value once the body is
+// written, so that mirrored files keep their original modification times.
+//
+// If the request carries an If-Match header, the file is only appended to
+// if its current entity tag, as reported by the ETag header of a GET
+// response, matches; otherwise, patch responds with 412 Precondition Failed.
+// The check and the write are separate SFTP operations, so concurrent
+// writers which do not use If-Match may still modify the file in between.
+//
+// Path rules, X-Last-Modified, If-Match, and the file's existence are all
+// checked before any of the request body is read, so a rejected request
+// does not consume its body.  When requests are served using
+// RoundTripper.Handler, this allows clients which send "Expect: 100-continue"
+// to avoid sending the body at all.  The size of the body is not limited.
+func (rt *RoundTripper) patch(p *clientPair, r *http.Request) (*http.Response, error) {
+	// Ensure this path may be served, hiding or forbidding it if needed
+	if err := rt.opts.checkPath(r.URL.Path); err != nil {
+		return rt.errorResponse(r, err)
+	}
+
+	// Validate any requested modification time before writing
+	modTime, ok := lastModified(r)
+	if !ok {
+		return rt.httpResponse(http.StatusBadRequest, nil, nil), nil
+	}
+
+	// Only modify the file if it has not changed since the client last
+	// retrieved it
+	ok, err := rt.ifMatchFile(p, r, r.URL.Path)
+	if err != nil {
+		return rt.errorResponse(r, err)
+	}
+	if !ok {
+		return rt.httpResponse(http.StatusPreconditionFailed, nil, nil), nil
+	}
+
+	// Open the file for appending; it must already exist
+	var f *sftp.File
+	err = rt.opts.retry(r.Context(), func() (err error) {
+		f, err = p.sftpc.OpenFile(r.URL.Path, os.O_WRONLY|os.O_APPEND)
+		return err
+	})
+	if err != nil {
+		return rt.errorResponse(r, err)
+	}
+	defer p.invalidate(r.URL.Path)
+
+	var sErr stickyError
+	size, err := appendFile(f, r.Body)
+	sErr.Set(err)
+	sErr.Set(f.Close())
+	if err := sErr.Get(); err != nil {
+		return rt.errorResponse(r, err)
+	}
+
+	if !modTime.IsZero() {
+		if err := p.sftpc.Chtimes(r.URL.Path, modTime, modTime); err != nil {
+			return rt.errorResponse(r, err)
+		}
+	}
+
+	body := strconv.FormatInt(size, 10) + "\n"
+	h := http.Header{}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return rt.httpResponse(http.StatusOK, io.NopCloser(strings.NewReader(body)), h), nil
+}
+
+// lastModified parses the X-Last-Modified header of r, which sets the
+// modification time of the files written by r.  It returns the zero time if
+// the header is not set, or false if it is not a valid HTTP date.
+func lastModified(r *http.Request) (time.Time, bool) {
+	v := r.Header.Get("X-Last-Modified")
+	if v == "" {
+		return time.Time{}, true
+	}
+
+	t, err := http.ParseTime(v)
+	return t, err == nil
+}
+
+// appendFile writes the contents of body to the end of f, and returns the
+// new size of f.  Some servers do not honor the append flag, so the file's
+// offset is explicitly set to its end before writing.
+func appendFile(f *sftp.File, body io.Reader) (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := fi.Size()
+
+	if body == nil {
+		return size, nil
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(f, body)
+	return size + n, err
+}
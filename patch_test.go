@@ -0,0 +1,274 @@
+package sshttp
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newPatchRequest creates a PATCH request which appends body to url.
+func newPatchRequest(t *testing.T, url string, body string) *http.Request {
+	t.Helper()
+
+	r, err := http.NewRequest(http.MethodPatch, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+
+	return r
+}
+
+func TestRoundTripperPatch(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "file.log", []byte("hello"))
+
+	rt := newTestRoundTripper(t, WithAppends(true))
+
+	var tests = []struct {
+		desc string
+		name string
+		body string
+		code int
+		size string
+		want string
+	}{
+		{
+			desc: "append",
+			name: "file.log",
+			body: " world",
+			code: http.StatusOK,
+			size: "11\n",
+			want: "hello world",
+		},
+		{
+			desc: "append again",
+			name: "file.log",
+			body: "!",
+			code: http.StatusOK,
+			size: "12\n",
+			want: "hello world!",
+		},
+		{
+			desc: "empty body",
+			name: "file.log",
+			code: http.StatusOK,
+			size: "12\n",
+			want: "hello world!",
+		},
+		{
+			desc: "missing",
+			name: "missing.log",
+			body: "hello",
+			code: http.StatusNotFound,
+		},
+	}
+
+	for i, tt := range tests {
+		res, b := do(t, rt, newPatchRequest(t, s.url(tt.name), tt.body))
+		if want, got := tt.code, res.StatusCode; want != got {
+			t.Fatalf("[%02d] test %q, unexpected status code: %v != %v",
+				i, tt.desc, want, got)
+		}
+
+		f, err := os.ReadFile(filepath.FromSlash(s.path(tt.name)))
+		if tt.code != http.StatusOK {
+			// Missing files must not be created
+			if !os.IsNotExist(err) {
+				t.Fatalf("[%02d] test %q, file unexpectedly exists: %v",
+					i, tt.desc, err)
+			}
+
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to read file: %v", i, tt.desc, err)
+		}
+
+		if want, got := tt.size, string(b); want != got {
+			t.Fatalf("[%02d] test %q, unexpected size: %q != %q",
+				i, tt.desc, want, got)
+		}
+		if want, got := tt.want, string(f); want != got {
+			t.Fatalf("[%02d] test %q, unexpected contents: %q != %q",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestRoundTripperPatchLastModified(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "file.log", []byte("hello"))
+
+	rt := newTestRoundTripper(t, WithAppends(true))
+
+	r := newPatchRequest(t, s.url("file.log"), " world")
+	modTime := time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)
+	r.Header.Set("X-Last-Modified", modTime.Format(http.TimeFormat))
+
+	res, _ := do(t, rt, r)
+	if want, got := http.StatusOK, res.StatusCode; want != got {
+		t.Fatalf("unexpected status code: %v != %v", want, got)
+	}
+
+	fi, err := os.Stat(filepath.FromSlash(s.path("file.log")))
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+	if want, got := modTime, fi.ModTime(); !want.Equal(got) {
+		t.Fatalf("unexpected modification time: %v != %v", want, got)
+	}
+}
+
+func TestRoundTripperPatchDisabled(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "file.log", []byte("hello"))
+
+	var tests = []struct {
+		desc  string
+		opts  []Option
+		code  int
+		allow string
+		want  string
+	}{
+		{
+			desc:  "disabled",
+			code:  http.StatusMethodNotAllowed,
+			allow: "GET, OPTIONS",
+			want:  "hello",
+		},
+		{
+			desc:  "enabled",
+			opts:  []Option{WithAppends(true)},
+			code:  http.StatusOK,
+			allow: "GET, OPTIONS, PATCH",
+			want:  "hello world",
+		},
+	}
+
+	for i, tt := range tests {
+		rt := newTestRoundTripper(t, tt.opts...)
+
+		res, _ := do(t, rt, newRequest(t, http.MethodOptions, s.url("file.log")))
+		if want, got := tt.allow, res.Header.Get("Allow"); want != got {
+			t.Fatalf("[%02d] test %q, unexpected Allow header: %q != %q",
+				i, tt.desc, want, got)
+		}
+
+		res, _ = do(t, rt, newPatchRequest(t, s.url("file.log"), " world"))
+		if want, got := tt.code, res.StatusCode; want != got {
+			t.Fatalf("[%02d] test %q, unexpected status code: %v != %v",
+				i, tt.desc, want, got)
+		}
+
+		b, err := os.ReadFile(filepath.FromSlash(s.path("file.log")))
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to read file: %v", i, tt.desc, err)
+		}
+		if want, got := tt.want, string(b); want != got {
+			t.Fatalf("[%02d] test %q, unexpected contents: %q != %q",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestRoundTripperPatchIfMatch(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "file", []byte("hello"))
+
+	rt := newTestRoundTripper(t, WithAppends(true))
+
+	// Entity tags are reported by GET requests
+	res, _ := do(t, rt, newRequest(t, http.MethodGet, s.url("file")))
+	tag := res.Header.Get("ETag")
+	if tag == "" {
+		t.Fatal("no ETag header in response")
+	}
+
+	var tests = []struct {
+		desc    string
+		name    string
+		ifMatch string
+		body    string
+		code    int
+		want    string
+	}{
+		{
+			desc:    "mismatch",
+			name:    "file",
+			ifMatch: `"bogus"`,
+			body:    " world",
+			code:    http.StatusPreconditionFailed,
+			want:    "hello",
+		},
+		{
+			desc:    "weak",
+			name:    "file",
+			ifMatch: "W/" + tag,
+			body:    " world",
+			code:    http.StatusPreconditionFailed,
+			want:    "hello",
+		},
+		{
+			desc:    "match",
+			name:    "file",
+			ifMatch: `"bogus", ` + tag,
+			body:    " world",
+			code:    http.StatusOK,
+			want:    "hello world",
+		},
+		{
+			desc:    "stale",
+			name:    "file",
+			ifMatch: tag,
+			body:    "!",
+			code:    http.StatusPreconditionFailed,
+			want:    "hello world",
+		},
+		{
+			desc:    "wildcard",
+			name:    "file",
+			ifMatch: "*",
+			body:    "!",
+			code:    http.StatusOK,
+			want:    "hello world!",
+		},
+		{
+			desc:    "wildcard missing",
+			name:    "missing",
+			ifMatch: "*",
+			body:    "!",
+			code:    http.StatusPreconditionFailed,
+		},
+	}
+
+	for i, tt := range tests {
+		r := newPatchRequest(t, s.url(tt.name), tt.body)
+		r.Header.Set("If-Match", tt.ifMatch)
+
+		res, _ := do(t, rt, r)
+		if want, got := tt.code, res.StatusCode; want != got {
+			t.Fatalf("[%02d] test %q, unexpected status code: %v != %v",
+				i, tt.desc, want, got)
+		}
+
+		b, err := os.ReadFile(filepath.FromSlash(s.path(tt.name)))
+		if tt.want == "" {
+			if !os.IsNotExist(err) {
+				t.Fatalf("[%02d] test %q, file unexpectedly exists: %v",
+					i, tt.desc, err)
+			}
+
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to read file: %v", i, tt.desc, err)
+		}
+		if want, got := tt.want, string(b); want != got {
+			t.Fatalf("[%02d] test %q, unexpected contents: %q != %q",
+				i, tt.desc, want, got)
+		}
+	}
+}
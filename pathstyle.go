@@ -0,0 +1,62 @@
+package sshttp
+
+import (
+	"path"
+	"strings"
+)
+
+// A PathStyle describes the form of paths on a remote host.
+type PathStyle int
+
+const (
+	// PathPOSIX indicates that remote paths use forward slashes, such as
+	// /home/foo.  It is the default.
+	PathPOSIX PathStyle = iota
+
+	// PathWindows indicates that remote paths may use backslashes and
+	// drive letters, such as C:\Users\foo, as accepted by SFTP servers
+	// running on Windows.
+	PathWindows
+)
+
+// WithPathStyle configures the style of paths used by remote hosts.  Remote
+// paths are always handled using forward slashes, regardless of the local
+// operating system.
+//
+// With PathWindows, backslashes in requested paths, in the root directory
+// of a FileSystem, and in symbolic link targets are treated as separators,
+// so that they cannot be used to escape the root directory.  Paths which
+// begin with a drive letter, such as C:\Users\foo, are rooted in the form
+// expected by SFTP servers on Windows, such as /C:/Users/foo.
+func WithPathStyle(style PathStyle) Option {
+	return func(o *options) {
+		o.pathStyle = style
+	}
+}
+
+// slashPath converts the remote path p to use forward slashes, according to
+// the configured path style.  Windows paths which begin with a drive letter
+// are made absolute.
+func (o *options) slashPath(p string) string {
+	if o.pathStyle != PathWindows {
+		return p
+	}
+
+	p = strings.ReplaceAll(p, `\`, "/")
+	if len(p) >= 2 && p[1] == ':' && isLetter(p[0]) {
+		p = "/" + p
+	}
+
+	return p
+}
+
+// cleanPath returns the cleaned, absolute form of the remote path p,
+// according to the configured path style.
+func (o *options) cleanPath(p string) string {
+	return path.Clean("/" + o.slashPath(p))
+}
+
+// isLetter reports whether b is an ASCII letter.
+func isLetter(b byte) bool {
+	return ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
@@ -0,0 +1,32 @@
+package sshttp
+
+import (
+	"testing"
+)
+
+func TestOptionsCleanPath(t *testing.T) {
+	var tests = []struct {
+		style PathStyle
+		path  string
+		want  string
+	}{
+		{style: PathPOSIX, path: "", want: "/"},
+		{style: PathPOSIX, path: ".", want: "/"},
+		{style: PathPOSIX, path: "foo/bar/", want: "/foo/bar"},
+		{style: PathPOSIX, path: "/foo/../../bar", want: "/bar"},
+		{style: PathPOSIX, path: `foo\..\bar`, want: `/foo\..\bar`},
+		{style: PathWindows, path: `foo\..\bar`, want: "/bar"},
+		{style: PathWindows, path: `\..\..\etc`, want: "/etc"},
+		{style: PathWindows, path: `C:\Users\foo`, want: "/C:/Users/foo"},
+		{style: PathWindows, path: "/C:/Users/../foo", want: "/C:/foo"},
+		{style: PathWindows, path: "1:/foo", want: "/1:/foo"},
+	}
+
+	for i, tt := range tests {
+		o := newOptions([]Option{WithPathStyle(tt.style)})
+		if want, got := tt.want, o.cleanPath(tt.path); want != got {
+			t.Fatalf("[%02d] path %q, unexpected clean path: %q != %q",
+				i, tt.path, want, got)
+		}
+	}
+}
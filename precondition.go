@@ -0,0 +1,67 @@
+package sshttp
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// etag returns the entity tag for the file described by fi, which changes
+// whenever the file's modification time or size changes.  The format is the
+// same as that used by golang.org/x/net/webdav, so that entity tags reported
+// by a RoundTripper and by a WebDAV share of the same files are consistent.
+func etag(fi os.FileInfo) string {
+	return fmt.Sprintf(`"%x%x"`, fi.ModTime().UnixNano(), fi.Size())
+}
+
+// ifMatch evaluates the If-Match header of r against the current state of a
+// file described by fi, which is nil if the file does not exist, and reports
+// whether a request which modifies the file may proceed.  Requests without
+// an If-Match header always proceed.  Otherwise, the file must exist, and
+// the header must either be "*" or list the file's entity tag.  Weak entity
+// tags never match, as required by RFC 9110.
+func ifMatch(r *http.Request, fi os.FileInfo) bool {
+	values := r.Header.Values("If-Match")
+	if len(values) == 0 {
+		return true
+	}
+	if fi == nil {
+		return false
+	}
+
+	tag := etag(fi)
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t == "*" || t == tag {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ifMatchFile evaluates the If-Match header of r against the remote file
+// fpath, in the same manner as ifMatch.  The file is only checked if r has
+// an If-Match header.
+func (rt *RoundTripper) ifMatchFile(p *clientPair, r *http.Request, fpath string) (bool, error) {
+	if len(r.Header.Values("If-Match")) == 0 {
+		return true, nil
+	}
+
+	var fi os.FileInfo
+	err := rt.opts.retry(r.Context(), func() (err error) {
+		fi, err = p.sftpc.Stat(fpath)
+		return err
+	})
+	if err != nil {
+		if os.IsNotExist(fsError(err)) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return ifMatch(r, fi), nil
+}
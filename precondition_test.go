@@ -0,0 +1,203 @@
+package sshttp
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sizedFileInfo is an os.FileInfo with a size and modification time.
+type sizedFileInfo struct {
+	testFileInfo
+	size    int64
+	modTime time.Time
+}
+
+func (fi sizedFileInfo) Size() int64        { return fi.size }
+func (fi sizedFileInfo) ModTime() time.Time { return fi.modTime }
+
+func TestIfMatch(t *testing.T) {
+	fi := sizedFileInfo{
+		testFileInfo: "file",
+		size:         10,
+		modTime:      time.Unix(1, 0),
+	}
+
+	var tests = []struct {
+		desc   string
+		values []string
+		fi     os.FileInfo
+		ok     bool
+	}{
+		{
+			desc: "no header",
+			fi:   fi,
+			ok:   true,
+		},
+		{
+			desc: "no header, missing file",
+			ok:   true,
+		},
+		{
+			desc:   "match",
+			values: []string{`"3b9aca00a"`},
+			fi:     fi,
+			ok:     true,
+		},
+		{
+			desc:   "match in list",
+			values: []string{`"foo", "3b9aca00a"`},
+			fi:     fi,
+			ok:     true,
+		},
+		{
+			desc:   "match in second header",
+			values: []string{`"foo"`, `"3b9aca00a"`},
+			fi:     fi,
+			ok:     true,
+		},
+		{
+			desc:   "wildcard",
+			values: []string{"*"},
+			fi:     fi,
+			ok:     true,
+		},
+		{
+			desc:   "wildcard, missing file",
+			values: []string{"*"},
+		},
+		{
+			desc:   "weak",
+			values: []string{`W/"3b9aca00a"`},
+			fi:     fi,
+		},
+		{
+			desc:   "mismatch",
+			values: []string{`"foo"`},
+			fi:     fi,
+		},
+	}
+
+	for i, tt := range tests {
+		r := &http.Request{Header: http.Header{}}
+		for _, v := range tt.values {
+			r.Header.Add("If-Match", v)
+		}
+
+		if want, got := tt.ok, ifMatch(r, tt.fi); want != got {
+			t.Fatalf("[%02d] test %q, unexpected result: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+// readRecorder is an io.Reader which records whether it has been read.
+type readRecorder struct {
+	r    io.Reader
+	read bool
+}
+
+func (r *readRecorder) Read(b []byte) (int, error) {
+	r.read = true
+	return r.r.Read(b)
+}
+
+func TestRoundTripperPreconditionsBeforeBody(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "file", []byte("hello"))
+	s.writeFile(t, "secret/file", []byte("hello"))
+
+	rt := newTestRoundTripper(t,
+		WithAppends(true),
+		WithUploads(true),
+		WithDenyGlobs(s.path("secret"), s.path("secret/*")),
+	)
+
+	var tests = []struct {
+		desc   string
+		method string
+		name   string
+		header http.Header
+		code   int
+		read   bool
+	}{
+		{
+			desc:   "PATCH If-Match mismatch",
+			method: http.MethodPatch,
+			name:   "file",
+			header: http.Header{"If-Match": {`"bogus"`}},
+			code:   http.StatusPreconditionFailed,
+		},
+		{
+			desc:   "PATCH invalid X-Last-Modified",
+			method: http.MethodPatch,
+			name:   "file",
+			header: http.Header{"X-Last-Modified": {"yesterday"}},
+			code:   http.StatusBadRequest,
+		},
+		{
+			desc:   "PATCH missing file",
+			method: http.MethodPatch,
+			name:   "missing",
+			code:   http.StatusNotFound,
+		},
+		{
+			desc:   "PATCH denied path",
+			method: http.MethodPatch,
+			name:   "secret/file",
+			code:   http.StatusForbidden,
+		},
+		{
+			desc:   "upload missing directory",
+			method: http.MethodPost,
+			name:   "missing",
+			header: http.Header{"Content-Type": {"multipart/form-data; boundary=x"}},
+			code:   http.StatusNotFound,
+		},
+		{
+			desc:   "upload not a directory",
+			method: http.MethodPost,
+			name:   "file",
+			header: http.Header{"Content-Type": {"multipart/form-data; boundary=x"}},
+			code:   http.StatusConflict,
+		},
+		{
+			desc:   "upload denied path",
+			method: http.MethodPost,
+			name:   "secret",
+			header: http.Header{"Content-Type": {"multipart/form-data; boundary=x"}},
+			code:   http.StatusForbidden,
+		},
+		{
+			desc:   "PATCH accepted",
+			method: http.MethodPatch,
+			name:   "file",
+			code:   http.StatusOK,
+			read:   true,
+		},
+	}
+
+	for i, tt := range tests {
+		body := &readRecorder{r: strings.NewReader(" world")}
+		r, err := http.NewRequest(tt.method, s.url(tt.name), body)
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to create request: %v", i, tt.desc, err)
+		}
+		for k, v := range tt.header {
+			r.Header[k] = v
+		}
+
+		res, _ := do(t, rt, r)
+		if want, got := tt.code, res.StatusCode; want != got {
+			t.Fatalf("[%02d] test %q, unexpected status code: %v != %v",
+				i, tt.desc, want, got)
+		}
+		if want, got := tt.read, body.read; want != got {
+			t.Fatalf("[%02d] test %q, unexpected body read: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
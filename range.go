@@ -0,0 +1,288 @@
+package sshttp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRanges is the maximum number of ranges which may be requested using the
+// Range header.  Requests for more ranges are served the entire file, so that
+// clients cannot request many tiny ranges to cause excessive work.
+const maxRanges = 16
+
+// maxBytesHeader is the request header which limits the number of bytes of a
+// file which are served, such as for a preview of its contents.
+const maxBytesHeader = "X-Max-Bytes"
+
+// errUnsatisfiableRange indicates that none of the ranges requested using
+// the Range header overlap a file.
+var errUnsatisfiableRange = errors.New("sshttp: unsatisfiable range")
+
+// byteRange is a range of bytes requested using the Range header.
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+// contentRange returns the value of the Content-Range header for a range of
+// a file with the specified size.
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRanges parses the value s of a Range header for a file with the
+// specified size.  Ranges may specify both offsets (bytes=0-499), only a
+// start offset (bytes=500-), or a number of bytes at the end of the file
+// (bytes=-500), and are clamped to the size of the file.
+//
+// If s is empty or malformed, parseRanges returns no ranges, and the entire
+// file should be served.  If no requested range overlaps the file,
+// parseRanges returns errUnsatisfiableRange.
+func parseRanges(s string, size int64) ([]byteRange, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(s, prefix) {
+		return nil, nil
+	}
+
+	var (
+		ranges []byteRange
+		parsed bool
+	)
+
+	for _, spec := range strings.Split(s[len(prefix):], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, nil
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		// Suffix ranges request the final bytes of the file
+		if first == "" {
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			parsed = true
+
+			if n == 0 || size == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+
+			ranges = append(ranges, byteRange{
+				start:  size - n,
+				length: n,
+			})
+			continue
+		}
+
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, nil
+		}
+
+		// Open-ended ranges continue to the end of the file
+		end := size - 1
+		if last != "" {
+			e, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || e < start {
+				return nil, nil
+			}
+			if e < end {
+				end = e
+			}
+		}
+		parsed = true
+
+		if start >= size {
+			continue
+		}
+
+		ranges = append(ranges, byteRange{
+			start:  start,
+			length: end - start + 1,
+		})
+	}
+
+	if parsed && len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+
+	return ranges, nil
+}
+
+// ifRange reports whether the Range header of r should be honored for a file
+// with modification time modTime.  If r has an If-Range header, it must be a
+// HTTP date exactly matching modTime.  Entity tags never match, as none are
+// issued.
+func ifRange(r *http.Request, modTime time.Time) bool {
+	v := r.Header.Get("If-Range")
+	if v == "" {
+		return true
+	}
+
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return false
+	}
+
+	// HTTP dates have a resolution of one second
+	return t.Equal(modTime.Truncate(time.Second))
+}
+
+// maxBytes parses the X-Max-Bytes header of r, which limits the number of
+// bytes served for a request.  It returns 0 if the header is not set, and
+// false if it is malformed.
+func maxBytes(r *http.Request) (int64, bool) {
+	v := r.Header.Get(maxBytesHeader)
+	if v == "" {
+		return 0, true
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// limitRanges truncates ranges so that they cover at most n bytes of a file
+// with the specified size, dropping any ranges beyond the limit.  If ranges
+// is empty, the entire file is limited.  If n is 0, ranges are returned
+// unmodified.
+func limitRanges(ranges []byteRange, size int64, n int64) []byteRange {
+	if n <= 0 {
+		return ranges
+	}
+	if len(ranges) == 0 {
+		ranges = []byteRange{{start: 0, length: size}}
+	}
+
+	out := make([]byteRange, 0, len(ranges))
+	for _, r := range ranges {
+		if n == 0 {
+			break
+		}
+		if r.length > n {
+			r.length = n
+		}
+		if r.length == 0 {
+			continue
+		}
+
+		out = append(out, r)
+		n -= r.length
+	}
+
+	return out
+}
+
+// clipRanges limits ranges to the first n bytes of a file, dropping any
+// ranges which begin at or beyond n.
+func clipRanges(ranges []byteRange, n int64) []byteRange {
+	out := make([]byteRange, 0, len(ranges))
+	for _, r := range ranges {
+		if r.start >= n {
+			continue
+		}
+		if end := r.start + r.length; end > n {
+			r.length = n - r.start
+		}
+
+		out = append(out, r)
+	}
+
+	return out
+}
+
+// sumRanges returns the total number of bytes covered by ranges.
+func sumRanges(ranges []byteRange) int64 {
+	var n int64
+	for _, r := range ranges {
+		n += r.length
+	}
+
+	return n
+}
+
+// multipartRanges returns a multipart/byteranges body which serves each of
+// ranges from f, a file with the specified size and content type cType.  It
+// also returns the length and content type of the body.  Closing the body
+// closes f.
+func multipartRanges(f io.ReadSeekCloser, ranges []byteRange, size int64, cType string) (io.ReadCloser, int64, string) {
+	// Render the framing of each part ahead of time, so that the length
+	// of the body is known before any data is read
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	var (
+		readers []io.Reader
+		n       int64
+	)
+	for _, r := range ranges {
+		// Writing to a bytes.Buffer cannot fail
+		_, _ = mw.CreatePart(textproto.MIMEHeader{
+			"Content-Range": {r.contentRange(size)},
+			"Content-Type":  {cType},
+		})
+
+		readers = append(readers,
+			bytes.NewReader(bytes.Clone(buf.Bytes())),
+			&rangeReader{f: f, r: r},
+		)
+		n += int64(buf.Len()) + r.length
+		buf.Reset()
+	}
+	_ = mw.Close()
+	readers = append(readers, bytes.NewReader(buf.Bytes()))
+	n += int64(buf.Len())
+
+	body := &rangesBody{
+		Reader: io.MultiReader(readers...),
+		Closer: f,
+	}
+
+	return body, n, "multipart/byteranges; boundary=" + mw.Boundary()
+}
+
+// rangesBody is the body of a multipart/byteranges response.
+type rangesBody struct {
+	io.Reader
+	io.Closer
+}
+
+// rangeReader is an io.Reader which reads a single range of a file, seeking
+// to the beginning of the range on the first read.
+type rangeReader struct {
+	f  io.ReadSeeker
+	r  byteRange
+	lr io.Reader
+}
+
+// Read implements io.Reader.
+func (rr *rangeReader) Read(b []byte) (int, error) {
+	if rr.lr == nil {
+		if _, err := rr.f.Seek(rr.r.start, io.SeekStart); err != nil {
+			return 0, err
+		}
+		rr.lr = io.LimitReader(rr.f, rr.r.length)
+	}
+
+	return rr.lr.Read(b)
+}
@@ -0,0 +1,308 @@
+package sshttp
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRanges(t *testing.T) {
+	var tests = []struct {
+		desc   string
+		s      string
+		size   int64
+		ranges []byteRange
+		err    error
+	}{
+		{
+			desc: "empty",
+			size: 10,
+		},
+		{
+			desc: "wrong unit",
+			s:    "items=0-4",
+			size: 10,
+		},
+		{
+			desc:   "bounded",
+			s:      "bytes=0-4",
+			size:   10,
+			ranges: []byteRange{{start: 0, length: 5}},
+		},
+		{
+			desc:   "open-ended",
+			s:      "bytes=5-",
+			size:   10,
+			ranges: []byteRange{{start: 5, length: 5}},
+		},
+		{
+			desc:   "suffix",
+			s:      "bytes=-3",
+			size:   10,
+			ranges: []byteRange{{start: 7, length: 3}},
+		},
+		{
+			desc:   "clamped suffix",
+			s:      "bytes=-30",
+			size:   10,
+			ranges: []byteRange{{start: 0, length: 10}},
+		},
+		{
+			desc:   "clamped end",
+			s:      "bytes=8-100",
+			size:   10,
+			ranges: []byteRange{{start: 8, length: 2}},
+		},
+		{
+			desc: "start beyond end",
+			s:    "bytes=10-",
+			size: 10,
+			err:  errUnsatisfiableRange,
+		},
+		{
+			desc: "zero suffix",
+			s:    "bytes=-0",
+			size: 10,
+			err:  errUnsatisfiableRange,
+		},
+		{
+			desc: "empty file",
+			s:    "bytes=-5",
+			size: 0,
+			err:  errUnsatisfiableRange,
+		},
+		{
+			desc: "end before start",
+			s:    "bytes=5-2",
+			size: 10,
+		},
+		{
+			desc: "malformed",
+			s:    "bytes=x",
+			size: 10,
+		},
+		{
+			desc: "negative start",
+			s:    "bytes=-1-5",
+			size: 10,
+		},
+		{
+			desc: "multiple",
+			s:    "bytes=0-1, 4-5,",
+			size: 10,
+			ranges: []byteRange{
+				{start: 0, length: 2},
+				{start: 4, length: 2},
+			},
+		},
+		{
+			desc:   "one satisfiable",
+			s:      "bytes=20-30,0-0",
+			size:   10,
+			ranges: []byteRange{{start: 0, length: 1}},
+		},
+	}
+
+	for i, tt := range tests {
+		ranges, err := parseRanges(tt.s, tt.size)
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("[%02d] test %q, unexpected error: %v != %v",
+				i, tt.desc, want, got)
+		}
+
+		if want, got := tt.ranges, ranges; !reflect.DeepEqual(want, got) {
+			t.Fatalf("[%02d] test %q, unexpected ranges: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestLimitRanges(t *testing.T) {
+	var tests = []struct {
+		desc   string
+		ranges []byteRange
+		size   int64
+		n      int64
+		want   []byteRange
+	}{
+		{
+			desc:   "no limit",
+			ranges: []byteRange{{start: 0, length: 5}},
+			size:   10,
+			want:   []byteRange{{start: 0, length: 5}},
+		},
+		{
+			desc: "no limit or ranges",
+			size: 10,
+		},
+		{
+			desc: "entire file",
+			size: 10,
+			n:    4,
+			want: []byteRange{{start: 0, length: 4}},
+		},
+		{
+			desc: "entire file within limit",
+			size: 10,
+			n:    20,
+			want: []byteRange{{start: 0, length: 10}},
+		},
+		{
+			desc: "empty file",
+			size: 0,
+			n:    4,
+			want: []byteRange{},
+		},
+		{
+			desc: "truncated range",
+			ranges: []byteRange{
+				{start: 2, length: 5},
+			},
+			size: 10,
+			n:    3,
+			want: []byteRange{{start: 2, length: 3}},
+		},
+		{
+			desc: "dropped ranges",
+			ranges: []byteRange{
+				{start: 0, length: 2},
+				{start: 4, length: 2},
+				{start: 8, length: 2},
+			},
+			size: 10,
+			n:    3,
+			want: []byteRange{
+				{start: 0, length: 2},
+				{start: 4, length: 1},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		if want, got := tt.want, limitRanges(tt.ranges, tt.size, tt.n); !reflect.DeepEqual(want, got) {
+			t.Fatalf("[%02d] test %q, unexpected ranges: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestClipRanges(t *testing.T) {
+	var tests = []struct {
+		desc   string
+		ranges []byteRange
+		n      int64
+		want   []byteRange
+	}{
+		{
+			desc:   "within limit",
+			ranges: []byteRange{{start: 0, length: 5}},
+			n:      10,
+			want:   []byteRange{{start: 0, length: 5}},
+		},
+		{
+			desc:   "crosses limit",
+			ranges: []byteRange{{start: 5, length: 10}},
+			n:      10,
+			want:   []byteRange{{start: 5, length: 5}},
+		},
+		{
+			desc: "beyond limit",
+			ranges: []byteRange{
+				{start: 0, length: 2},
+				{start: 10, length: 2},
+				{start: 20, length: 2},
+			},
+			n:    10,
+			want: []byteRange{{start: 0, length: 2}},
+		},
+		{
+			desc:   "all beyond limit",
+			ranges: []byteRange{{start: 10, length: 2}},
+			n:      10,
+			want:   []byteRange{},
+		},
+	}
+
+	for i, tt := range tests {
+		if want, got := tt.want, clipRanges(tt.ranges, tt.n); !reflect.DeepEqual(want, got) {
+			t.Fatalf("[%02d] test %q, unexpected ranges: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestIfRange(t *testing.T) {
+	modTime := time.Date(2020, time.January, 2, 3, 4, 5, 600, time.UTC)
+
+	var tests = []struct {
+		desc   string
+		header string
+		ok     bool
+	}{
+		{
+			desc: "no header",
+			ok:   true,
+		},
+		{
+			desc:   "matching date",
+			header: modTime.Format(http.TimeFormat),
+			ok:     true,
+		},
+		{
+			desc:   "earlier date",
+			header: modTime.Add(-time.Second).Format(http.TimeFormat),
+		},
+		{
+			desc:   "later date",
+			header: modTime.Add(time.Second).Format(http.TimeFormat),
+		},
+		{
+			desc:   "entity tag",
+			header: `"abc"`,
+		},
+	}
+
+	for i, tt := range tests {
+		r := &http.Request{Header: http.Header{}}
+		if tt.header != "" {
+			r.Header.Set("If-Range", tt.header)
+		}
+
+		if want, got := tt.ok, ifRange(r, modTime); want != got {
+			t.Fatalf("[%02d] test %q, unexpected result: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	var tests = []struct {
+		header string
+		n      int64
+		ok     bool
+	}{
+		{header: "", n: 0, ok: true},
+		{header: "100", n: 100, ok: true},
+		{header: "0", ok: false},
+		{header: "-5", ok: false},
+		{header: "foo", ok: false},
+	}
+
+	for i, tt := range tests {
+		r := &http.Request{Header: http.Header{}}
+		if tt.header != "" {
+			r.Header.Set(maxBytesHeader, tt.header)
+		}
+
+		n, ok := maxBytes(r)
+		if want, got := tt.ok, ok; want != got {
+			t.Fatalf("[%02d] header %q, unexpected ok: %v != %v",
+				i, tt.header, want, got)
+		}
+		if want, got := tt.n, n; want != got {
+			t.Fatalf("[%02d] header %q, unexpected limit: %v != %v",
+				i, tt.header, want, got)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package sshttp
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// WithRateLimit configures a RoundTripper to limit the throughput of each
+// response body it streams to bytesPerSecond.  A value of zero or less
+// disables the limit, which is the default.
+func WithRateLimit(bytesPerSecond int) Option {
+	return func(o *options) {
+		o.rateLimit = bytesPerSecond
+	}
+}
+
+// WithGlobalRateLimit configures a RoundTripper to limit the combined
+// throughput of all response bodies it streams to bytesPerSecond.  It may be
+// combined with WithRateLimit.  A value of zero or less disables the limit,
+// which is the default.
+func WithGlobalRateLimit(bytesPerSecond int) Option {
+	return func(o *options) {
+		if bytesPerSecond <= 0 {
+			o.globalLimiter = nil
+			return
+		}
+
+		o.globalLimiter = rate.NewLimiter(rate.Limit(bytesPerSecond), bytesPerSecond)
+	}
+}
+
+// limitWriter wraps w so that writes are limited by any configured rate
+// limits.  If no limits are configured, w is returned unmodified.
+func (o *options) limitWriter(ctx context.Context, w io.Writer) io.Writer {
+	var ls []*rate.Limiter
+	if o.rateLimit > 0 {
+		ls = append(ls, rate.NewLimiter(rate.Limit(o.rateLimit), o.rateLimit))
+	}
+	if o.globalLimiter != nil {
+		ls = append(ls, o.globalLimiter)
+	}
+	if len(ls) == 0 {
+		return w
+	}
+
+	// Writes are split into chunks no larger than the smallest burst,
+	// so that each chunk may be admitted by every limiter
+	burst := ls[0].Burst()
+	for _, l := range ls[1:] {
+		if b := l.Burst(); b < burst {
+			burst = b
+		}
+	}
+
+	return &rateWriter{
+		ctx:      ctx,
+		w:        w,
+		limiters: ls,
+		burst:    burst,
+	}
+}
+
+// rateWriter is an io.Writer which waits for one or more rate limiters to
+// admit data before writing it to its underlying io.Writer.
+type rateWriter struct {
+	ctx      context.Context
+	w        io.Writer
+	limiters []*rate.Limiter
+	burst    int
+}
+
+// Write implements io.Writer.
+func (w *rateWriter) Write(b []byte) (int, error) {
+	var n int
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > w.burst {
+			chunk = chunk[:w.burst]
+		}
+
+		for _, l := range w.limiters {
+			if err := l.WaitN(w.ctx, len(chunk)); err != nil {
+				return n, err
+			}
+		}
+
+		nn, err := w.w.Write(chunk)
+		n += nn
+		if err != nil {
+			return n, err
+		}
+
+		b = b[len(chunk):]
+	}
+
+	return n, nil
+}
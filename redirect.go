@@ -0,0 +1,148 @@
+package sshttp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrRedirectLoop is returned by RoundTripper when following redirects
+// between SFTP hosts would revisit a path which was already visited.
+var ErrRedirectLoop = errors.New("sshttp: redirect loop detected")
+
+// WithRedirects configures a RoundTripper to follow up to max redirects when
+// serving GET requests.  A redirect is a symbolic link on a remote host
+// whose target is a complete SFTP URL, such as sftp://10.0.0.2:22/data/foo,
+// which is created using a command such as:
+//
+//	ln -s sftp://10.0.0.2:22/data/foo foo
+//
+// The target host must have been dialed using Dial or DialConn, registered
+// using DialGroup, or match a pattern registered using Dial, and is dialed
+// in the same manner as any other host.  The target path is then requested
+// from it.  Redirects to any other host cause RoundTrip to return an error,
+// because anyone able to create symbolic links on a remote host could
+// otherwise cause the RoundTripper to dial a host of their choosing using
+// the default credentials set by NewRoundTripper, exposing them.  Symbolic
+// links at paths which may not be served, such as those hidden by
+// WithDenyDotfiles, are never followed as redirects.
+//
+// If following redirects would revisit a path, RoundTrip returns
+// ErrRedirectLoop, and if more than max redirects are encountered,
+// RoundTrip returns an error.  Because detecting redirects requires an
+// extra round trip for each request, redirects are not followed by default.
+func WithRedirects(max int) Option {
+	return func(o *options) {
+		o.maxRedirects = max
+	}
+}
+
+// redirect follows any redirects for r, if enabled, and then serves the
+// request for the final target.
+func (rt *RoundTripper) redirect(r *http.Request) (*http.Response, error) {
+	if rt.opts.maxRedirects <= 0 || r.Method != http.MethodGet {
+		return rt.failover(r)
+	}
+
+	visited := make(map[string]bool)
+	for i := 0; ; i++ {
+		key := r.URL.Host + r.URL.Path
+		if visited[key] {
+			return nil, ErrRedirectLoop
+		}
+		visited[key] = true
+
+		// Paths which may not be served are never redirects, and are
+		// hidden or forbidden when the request is served
+		if rt.opts.checkPath(r.URL.Path) != nil {
+			break
+		}
+
+		p, err := rt.connect(r.URL.Host)
+		if err != nil {
+			// Let failover handle an unreachable host
+			break
+		}
+
+		target, err := readRedirect(p, r.URL.Path)
+		if err != nil {
+			return nil, err
+		}
+		if target == nil {
+			break
+		}
+		if i >= rt.opts.maxRedirects {
+			return nil, fmt.Errorf("sshttp: stopped after %d redirects", rt.opts.maxRedirects)
+		}
+		if !rt.registered(target.Host) {
+			return nil, fmt.Errorf("sshttp: redirect to unregistered host %q", target.Host)
+		}
+
+		r = r.Clone(r.Context())
+		r.URL = target
+		r.Host = target.Host
+	}
+
+	return rt.failover(r)
+}
+
+// connect returns a connection to a host which may serve requests for host,
+// resolving groups registered using DialGroup and backups configured by
+// WithFailover in the same manner as failover.
+func (rt *RoundTripper) connect(host string) (*clientPair, error) {
+	hosts, g, members := rt.route(host, false)
+
+	var err error
+	for i, h := range hosts {
+		var config *ssh.ClientConfig
+		if i < members {
+			config = g.config
+		}
+
+		var p *clientPair
+		p, err = rt.lazyDial(h, config)
+		if err == nil {
+			return p, nil
+		}
+		if i < members {
+			rt.markDown(g, h)
+		}
+	}
+
+	return nil, err
+}
+
+// readRedirect determines if name is a redirect to another SFTP URL, and
+// returns the URL if so.  If name is not a redirect, it returns nil.
+func readRedirect(p *clientPair, name string) (*url.URL, error) {
+	fi, err := p.sftpc.Lstat(name)
+	if err != nil {
+		// A missing file is not a redirect, and will be reported when
+		// the request is served
+		if os.IsNotExist(fsError(err)) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return nil, nil
+	}
+
+	target, err := p.sftpc.ReadLink(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ordinary symbolic links are not redirects
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != Protocol || u.Host == "" {
+		return nil, nil
+	}
+
+	return u, nil
+}
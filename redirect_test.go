@@ -0,0 +1,111 @@
+package sshttp
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRoundTripperRedirects(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "target", []byte("hello"))
+
+	// Redirects refer to a group, which must be resolved to a physical
+	// host both when detecting and when following them
+	group := Protocol + "://group"
+	for _, l := range []struct {
+		target string
+		name   string
+	}{
+		{target: group + s.path("target"), name: "single"},
+		{target: group + s.path("single"), name: "double"},
+		{target: group + s.path("b"), name: "a"},
+		{target: group + s.path("a"), name: "b"},
+		{target: Protocol + "://unregistered" + s.path("target"), name: "unregistered"},
+	} {
+		if err := os.Symlink(l.target, filepath.FromSlash(s.path(l.name))); err != nil {
+			t.Fatalf("failed to create symbolic link: %v", err)
+		}
+	}
+
+	var tests = []struct {
+		desc string
+		name string
+		max  int
+		ok   bool
+		err  error
+	}{
+		{
+			desc: "no redirect",
+			name: "target",
+			max:  1,
+			ok:   true,
+		},
+		{
+			desc: "single hop",
+			name: "single",
+			max:  1,
+			ok:   true,
+		},
+		{
+			desc: "two hops",
+			name: "double",
+			max:  2,
+			ok:   true,
+		},
+		{
+			desc: "too many hops",
+			name: "double",
+			max:  1,
+		},
+		{
+			desc: "loop",
+			name: "a",
+			max:  10,
+			err:  ErrRedirectLoop,
+		},
+		{
+			desc: "unregistered host",
+			name: "unregistered",
+			max:  1,
+		},
+	}
+
+	for i, tt := range tests {
+		rt := newTestRoundTripper(t, WithRedirects(tt.max))
+		if err := rt.DialGroup("group", []string{s.addr}, nil); err != nil {
+			t.Fatalf("[%02d] test %q, failed to dial group: %v", i, tt.desc, err)
+		}
+
+		res, err := rt.RoundTrip(newRequest(t, http.MethodGet, group+s.path(tt.name)))
+		if !tt.ok {
+			// Redirects which cannot be followed are errors
+			if err == nil {
+				_ = res.Body.Close()
+				t.Fatalf("[%02d] test %q, expected an error", i, tt.desc)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("[%02d] test %q, unexpected error: %v != %v",
+					i, tt.desc, tt.err, err)
+			}
+
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to perform request: %v", i, tt.desc, err)
+		}
+
+		b, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to read body: %v", i, tt.desc, err)
+		}
+		if want, got := "hello", string(b); want != got {
+			t.Fatalf("[%02d] test %q, unexpected body: %q != %q",
+				i, tt.desc, want, got)
+		}
+	}
+}
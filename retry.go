@@ -0,0 +1,112 @@
+package sshttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+const (
+	// sftpNoConnection and sftpConnectionLost are the error codes returned
+	// by SFTP when the connection to a server is unavailable.
+	sftpNoConnection   = 6
+	sftpConnectionLost = 7
+
+	// defaultBackoff is the initial delay between retries if none is
+	// specified using WithBackoff.
+	defaultBackoff = 100 * time.Millisecond
+)
+
+// WithMaxRetries configures the maximum number of times a RoundTripper or
+// FileSystem will retry opening or statting a remote file when a transient
+// error occurs, such as a reset connection or a timeout.  Errors which
+// indicate a permanent condition, such as a file not existing or permission
+// being denied, are never retried.  By default, no retries are performed.
+func WithMaxRetries(n int) Option {
+	return func(o *options) {
+		o.maxRetries = n
+	}
+}
+
+// WithBackoff configures the delay before the first retry when WithMaxRetries
+// is in use.  The delay doubles after each subsequent attempt.  If not set,
+// a delay of 100 milliseconds is used.
+func WithBackoff(d time.Duration) Option {
+	return func(o *options) {
+		o.backoff = d
+	}
+}
+
+// retry invokes fn until it succeeds, returns a permanent error, or the
+// maximum number of retries is exhausted.  The last error returned by fn
+// is returned.  If ctx is canceled, retry stops and returns ctx.Err.
+func (o *options) retry(ctx context.Context, fn func() error) error {
+	backoff := o.backoff
+	if backoff <= 0 {
+		backoff = defaultBackoff
+	}
+
+	for i := 0; ; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := fn()
+		if err == nil || i >= o.maxRetries || !isTransient(err) {
+			return err
+		}
+
+		t := time.NewTimer(backoff << uint(i))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// isTransient reports whether err is likely to be a temporary condition
+// which may succeed if the operation is retried.  Unknown errors are
+// considered permanent.
+func isTransient(err error) bool {
+	if errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var serr *sftp.StatusError
+	if errors.As(err, &serr) {
+		return serr.Code == sftpNoConnection || serr.Code == sftpConnectionLost
+	}
+
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
+
+// isConnectionLost reports whether err indicates that the SSH connection or
+// SFTP session used to serve a request has failed, so that the connection
+// must be dialed again before it can be used.
+func isConnectionLost(err error) bool {
+	if errors.Is(err, sftp.ErrSSHFxConnectionLost) ||
+		errors.Is(err, sftp.ErrSSHFxNoConnection) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var serr *sftp.StatusError
+	if errors.As(err, &serr) {
+		return serr.Code == sftpNoConnection || serr.Code == sftpConnectionLost
+	}
+
+	return false
+}
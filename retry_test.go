@@ -0,0 +1,216 @@
+package sshttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// timeoutError is a net.Error which reports a timeout.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTransient(t *testing.T) {
+	var tests = []struct {
+		desc string
+		err  error
+		ok   bool
+	}{
+		{
+			desc: "EAGAIN",
+			err:  syscall.EAGAIN,
+			ok:   true,
+		},
+		{
+			desc: "wrapped ECONNRESET",
+			err:  fmt.Errorf("read: %w", syscall.ECONNRESET),
+			ok:   true,
+		},
+		{
+			desc: "unexpected EOF",
+			err:  io.ErrUnexpectedEOF,
+			ok:   true,
+		},
+		{
+			desc: "connection lost",
+			err:  &sftp.StatusError{Code: sftpConnectionLost},
+			ok:   true,
+		},
+		{
+			desc: "no connection",
+			err:  &sftp.StatusError{Code: sftpNoConnection},
+			ok:   true,
+		},
+		{
+			desc: "timeout",
+			err:  timeoutError{},
+			ok:   true,
+		},
+		{
+			desc: "no such file",
+			err:  &sftp.StatusError{Code: sftpNoSuchFile},
+		},
+		{
+			desc: "permission denied",
+			err:  &sftp.StatusError{Code: sftpPermissionDenied},
+		},
+		{
+			desc: "not exist",
+			err:  os.ErrNotExist,
+		},
+		{
+			desc: "unknown",
+			err:  errors.New("unknown"),
+		},
+	}
+
+	for i, tt := range tests {
+		if want, got := tt.ok, isTransient(tt.err); want != got {
+			t.Fatalf("[%02d] test %q, unexpected result: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestIsConnectionLost(t *testing.T) {
+	var tests = []struct {
+		desc string
+		err  error
+		ok   bool
+	}{
+		{
+			desc: "SFTP connection lost",
+			err:  fmt.Errorf("open: %w", sftp.ErrSSHFxConnectionLost),
+			ok:   true,
+		},
+		{
+			desc: "SFTP status connection lost",
+			err:  &sftp.StatusError{Code: sftpConnectionLost},
+			ok:   true,
+		},
+		{
+			desc: "closed connection",
+			err:  net.ErrClosed,
+			ok:   true,
+		},
+		{
+			desc: "EOF",
+			err:  io.EOF,
+			ok:   true,
+		},
+		{
+			desc: "no such file",
+			err:  &sftp.StatusError{Code: sftpNoSuchFile},
+		},
+		{
+			desc: "timeout",
+			err:  timeoutError{},
+		},
+		{
+			desc: "unknown",
+			err:  errors.New("unknown"),
+		},
+	}
+
+	for i, tt := range tests {
+		if want, got := tt.ok, isConnectionLost(tt.err); want != got {
+			t.Fatalf("[%02d] test %q, unexpected result: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestOptionsRetry(t *testing.T) {
+	errPermanent := &sftp.StatusError{Code: sftpNoSuchFile}
+
+	var tests = []struct {
+		desc  string
+		opts  []Option
+		errs  []error
+		calls int
+		err   error
+	}{
+		{
+			desc:  "success",
+			opts:  []Option{WithMaxRetries(3)},
+			calls: 1,
+		},
+		{
+			desc:  "no retries",
+			errs:  []error{syscall.ECONNRESET},
+			calls: 1,
+			err:   syscall.ECONNRESET,
+		},
+		{
+			desc:  "succeeds on second attempt",
+			opts:  []Option{WithMaxRetries(3)},
+			errs:  []error{syscall.ECONNRESET},
+			calls: 2,
+		},
+		{
+			desc:  "retries exhausted",
+			opts:  []Option{WithMaxRetries(2)},
+			errs:  []error{syscall.EAGAIN, syscall.EAGAIN, syscall.EAGAIN, syscall.EAGAIN},
+			calls: 3,
+			err:   syscall.EAGAIN,
+		},
+		{
+			desc:  "permanent error",
+			opts:  []Option{WithMaxRetries(3)},
+			errs:  []error{errPermanent},
+			calls: 1,
+			err:   errPermanent,
+		},
+	}
+
+	for i, tt := range tests {
+		o := newOptions(append(tt.opts, WithBackoff(time.Millisecond)))
+
+		// Each call fails with the next error, if any
+		var calls int
+		err := o.retry(context.Background(), func() error {
+			calls++
+			if calls > len(tt.errs) {
+				return nil
+			}
+
+			return tt.errs[calls-1]
+		})
+
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("[%02d] test %q, unexpected error: %v != %v",
+				i, tt.desc, want, got)
+		}
+		if want, got := tt.calls, calls; want != got {
+			t.Fatalf("[%02d] test %q, unexpected number of calls: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestOptionsRetryCanceled(t *testing.T) {
+	o := newOptions([]Option{
+		WithMaxRetries(10),
+		WithBackoff(time.Hour),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := o.retry(ctx, func() error {
+		cancel()
+		return syscall.ECONNRESET
+	})
+	if want, got := context.Canceled, err; want != got {
+		t.Fatalf("unexpected error: %v != %v", want, got)
+	}
+}
@@ -1,23 +1,39 @@
 package sshttp
 
 import (
-	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"mime"
+	"net"
 	"net/http"
 	"os"
-	"path/filepath"
+	"path"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
+	// transferredBytesTrailer is the HTTP trailer which carries the number
+	// of bytes transferred in a response body.
+	transferredBytesTrailer = "X-Transferred-Bytes"
+
 	// sftpNoSuchFile is the error code returned by SFTP if access is attempted
 	// to a file which does not exist.
 	sftpNoSuchFile = 2
+
+	// sftpPermissionDenied is the error code returned by SFTP if access is
+	// attempted to a file without sufficient permissions.
+	sftpPermissionDenied = 3
 )
 
 // RoundTripper implements http.RoundTripper, and handles performing a HTTP
@@ -27,17 +43,44 @@ const (
 // method to configure each host on an individual basis.
 type RoundTripper struct {
 	config *ssh.ClientConfig
-	conn   map[string]*clientPair
+	opts   options
+
+	mu       sync.RWMutex
+	conn     map[string]*clientPair
+	groups   map[string]*hostGroup
+	patterns []hostPattern
+	closing  bool
+
+	// Configurations of hosts dialed explicitly using Dial or DialConn,
+	// used if they must be dialed again
+	configs map[string]*ssh.ClientConfig
+
+	// Deduplicates concurrent lazy dials to the same host
+	dials singleflight.Group
+
+	// Tracks active RoundTrips and response body transfers
+	wg sync.WaitGroup
+
+	// Total number of requests served and body bytes transferred by
+	// this RoundTripper
+	requests atomic.Int64
+	bytes    atomic.Int64
 }
 
 // NewRoundTripper accepts a ssh.ClientConfig struct and returns a
 // RoundTripper which can be used by net/http.  The configuration parameter
 // is used as the default for any SSH hosts which are not explicitly configured
 // using the Dial method.
-func NewRoundTripper(config *ssh.ClientConfig) *RoundTripper {
+//
+// Zero or more Option values may be specified to further configure the
+// RoundTripper.
+func NewRoundTripper(config *ssh.ClientConfig, opts ...Option) *RoundTripper {
 	return &RoundTripper{
-		config: config,
-		conn:   make(map[string]*clientPair),
+		config:  config,
+		conn:    make(map[string]*clientPair),
+		groups:  make(map[string]*hostGroup),
+		configs: make(map[string]*ssh.ClientConfig),
+		opts:    newOptions(opts),
 	}
 }
 
@@ -48,32 +91,155 @@ func NewRoundTripper(config *ssh.ClientConfig) *RoundTripper {
 // Dial should be used if more than a single host is being dialed by
 // RoundTripper, so that various SSH client configurations may be used, if
 // needed.  For a single host, allowing RoundTripper to lazily dial a host
-// using the default SSH client configuration is typically acceptable.
+// using the default SSH client configuration is typically acceptable.  The
+// configuration is reused if host must be dialed again, such as after its
+// connection is lost.
+//
+// If host contains the wildcards * or ?, such as *.example.com:22, it is
+// treated as a pattern recognized by path.Match, and Dial does not dial any
+// hosts.  Instead, the configuration is used when
+// RoundTripper lazily dials any host matching the pattern, unless that host
+// was dialed explicitly.  If more than one pattern matches a host, the
+// pattern which was registered first is used.
 func (rt *RoundTripper) Dial(host string, config *ssh.ClientConfig) error {
 	// Use default configuration if none specified
 	if config == nil {
 		config = rt.config
 	}
 
-	// Create clientPair with SSH and SFTP clients
-	pair, err := dialSSHSFTP(host, config)
+	// Store patterns for use when lazily dialing matching hosts.  Other
+	// pattern characters are not checked, as brackets appear in IPv6
+	// addresses.
+	if strings.ContainsAny(host, "*?") {
+		if _, err := path.Match(host, ""); err != nil {
+			return err
+		}
+
+		rt.mu.Lock()
+		rt.patterns = append(rt.patterns, hostPattern{
+			pattern: host,
+			config:  config,
+		})
+		rt.mu.Unlock()
+
+		return nil
+	}
+
+	if _, err := rt.dial(host, config); err != nil {
+		return err
+	}
+
+	rt.register(host, config)
+	return nil
+}
+
+// register records that host was dialed explicitly using config.
+func (rt *RoundTripper) register(host string, config *ssh.ClientConfig) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.configs[host] = config
+}
+
+// registered reports whether host was dialed explicitly using Dial or
+// DialConn, is a group registered using DialGroup, or matches a pattern
+// registered using Dial.
+func (rt *RoundTripper) registered(host string) bool {
+	rt.mu.RLock()
+	_, dialed := rt.configs[host]
+	_, grouped := rt.groups[host]
+	rt.mu.RUnlock()
+
+	return dialed || grouped || rt.patternConfig(host) != nil
+}
+
+// DialConn is like Dial, but establishes the SSH connection for host over
+// the existing network connection conn, such as a Unix domain socket or a
+// connection through a tunnel, instead of dialing host.  If the SSH
+// handshake fails, conn is closed.
+//
+// conn cannot be reused once it fails, so if the connection to host is later
+// lost, RoundTripper dials host again as usual.  To control how every
+// connection is established, use WithDialer instead.
+func (rt *RoundTripper) DialConn(host string, conn net.Conn, config *ssh.ClientConfig) error {
+	// Use default configuration if none specified
+	if config == nil {
+		config = rt.config
+	}
+
+	pair, err := newClientPair(conn, host, config, &rt.opts)
 	if err != nil {
 		return err
 	}
 
-	rt.conn[host] = pair
+	rt.store(host, pair)
+	rt.register(host, config)
 	return nil
 }
 
+// dial dials host using config, and stores the resulting connection in the
+// pool.
+func (rt *RoundTripper) dial(host string, config *ssh.ClientConfig) (*clientPair, error) {
+	// Create clientPair with SSH and SFTP clients
+	pair, err := dialSSHSFTP(host, config, &rt.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	rt.store(host, pair)
+	return pair, nil
+}
+
+// store adds the connection pair for host to the pool, closing any other
+// connection it replaces.
+func (rt *RoundTripper) store(host string, pair *clientPair) {
+	rt.mu.Lock()
+	old := rt.conn[host]
+	rt.conn[host] = pair
+	rt.mu.Unlock()
+
+	if old != nil && old != pair {
+		_ = old.close()
+	}
+
+	if rt.opts.idleTimeout > 0 {
+		go rt.reapIdle(host, pair)
+	}
+}
+
+// Warm concurrently dials each of the specified hosts, so that the first
+// request to each host does not incur the latency of dialing it.  Each host
+// is dialed in the same manner as RoundTrip, using the SSH client
+// configuration of the first matching pattern registered using Dial, or the
+// default set by NewRoundTripper.  Hosts which are already connected, or are
+// being dialed concurrently, are not dialed again.  If any hosts
+// cannot be dialed, Warm returns an error joining each of the failures.
+func (rt *RoundTripper) Warm(hosts ...string) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(hosts))
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+
+			// Share dials with any concurrent requests for the same host
+			_, errs[i] = rt.lazyDial(host, nil)
+		}(i, host)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
 // Close closes all open SFTP and SSH connections for this RoundTripper.
 func (rt *RoundTripper) Close() error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
 	// Attempt to close each SFTP and SSH connection.  Map iteration
 	// order is undefined in Go, but this is okay for our purposes.
 	for k := range rt.conn {
-		if err := rt.conn[k].sftpc.Close(); err != nil {
-			return err
-		}
-		if err := rt.conn[k].sshc.Close(); err != nil {
+		if err := rt.conn[k].close(); err != nil {
 			return err
 		}
 
@@ -83,137 +249,1017 @@ func (rt *RoundTripper) Close() error {
 	return nil
 }
 
+// Shutdown gracefully shuts down the RoundTripper.  Shutdown immediately
+// causes any new calls to RoundTrip to return ErrClosed, waits for active
+// requests to finish streaming their response bodies, and then closes all
+// open SFTP and SSH connections.
+//
+// If ctx expires before active requests finish, the connections are closed
+// anyway, interrupting any transfers, and ctx.Err is returned.
+func (rt *RoundTripper) Shutdown(ctx context.Context) error {
+	rt.mu.Lock()
+	rt.closing = true
+	rt.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		rt.wg.Wait()
+		close(done)
+	}()
+
+	var sErr stickyError
+	select {
+	case <-done:
+	case <-ctx.Done():
+		sErr.Set(ctx.Err())
+	}
+	sErr.Set(rt.Close())
+
+	return sErr.Get()
+}
+
 // RoundTrip implements http.RoundTripper, and performs a HTTP request over SSH,
 // using SFTP to coordinate the response.  If a SSH connection is not already
 // open to the host specified in r.URL.Host, RoundTrip will attempt to lazily
 // dial the host using the default configuration from NewRoundTripper.
+//
+// If backup hosts are configured for r.URL.Host using WithFailover, RoundTrip
+// tries each of them in turn if dialing or communicating with a host fails,
+// for GET and OPTIONS requests.
 func (rt *RoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
-	// Attempt to dial the request host, if needed
-	p, err := rt.lazyDial(r.URL.Host)
+	// Refuse new requests once shutdown begins, and track this request
+	// so that Shutdown can wait for it
+	rt.mu.RLock()
+	if rt.closing {
+		rt.mu.RUnlock()
+		return nil, ErrClosed
+	}
+	rt.wg.Add(1)
+	rt.mu.RUnlock()
+	defer rt.wg.Done()
+
+	rt.requests.Add(1)
+
+	// Apply request hooks, which may respond to the request without it
+	// being served
+	req := r
+	if len(rt.opts.requestHooks) > 0 {
+		hr, res, err := rt.opts.runRequestHooks(r)
+		if err != nil {
+			return nil, err
+		}
+		if res != nil {
+			res.Request = req
+			rt.opts.runResponseHooks(res)
+			return res, nil
+		}
+		r = hr
+	}
+
+	// Apply the default timeout to requests without a deadline.  The
+	// timeout is released once the response body is closed.
+	var cancel context.CancelFunc
+	if _, ok := r.Context().Deadline(); !ok && rt.opts.requestTimeout > 0 {
+		var ctx context.Context
+		ctx, cancel = context.WithTimeout(r.Context(), rt.opts.requestTimeout)
+		r = r.WithContext(ctx)
+	}
+
+	res, err := rt.redirect(r)
+	if err == nil {
+		// As required of a http.RoundTripper, the response refers to the
+		// original request
+		res.Request = req
+		rt.opts.setCORSHeaders(res.Header, r)
+	}
 	if err != nil {
+		if cancel != nil {
+			cancel()
+		}
 		return nil, err
 	}
 
+	if cancel != nil {
+		res.Body = &cancelBody{
+			ReadCloser: res.Body,
+			cancel:     cancel,
+		}
+	}
+	rt.opts.runResponseHooks(res)
+
+	return res, nil
+}
+
+// failover attempts to serve r using the host specified in r.URL.Host, and
+// then any backup hosts configured for it, until a host successfully produces
+// a HTTP response.  Only connection-level failures cause the next host to be
+// tried; HTTP error responses, such as 404, and errors for missing files
+// configured by WithNotFoundError, are returned immediately.
+//
+// If r.URL.Host names a group registered using DialGroup, the hosts in the
+// group are tried before any backup hosts.
+//
+// Only GET and OPTIONS requests are tried on more than one host.  Other
+// requests, such as PATCH, may have partially consumed their body or
+// modified the remote host before failing, so retrying them elsewhere could
+// apply them twice or incompletely.
+func (rt *RoundTripper) failover(r *http.Request) (*http.Response, error) {
+	hosts, g, members := rt.route(r.URL.Host, true)
+	grouped := g != nil
+	retry := r.Method == http.MethodGet || r.Method == http.MethodOptions
+
+	var err error
+	for i, host := range hosts {
+		if cerr := r.Context().Err(); cerr != nil {
+			return nil, cerr
+		}
+
+		if !grouped || i >= members {
+			var res *http.Response
+			res, err = rt.try(host, nil, r)
+			if err == nil || errors.Is(err, os.ErrNotExist) || !retry {
+				return res, err
+			}
+			continue
+		}
+
+		// Group members use the group's configuration, and their
+		// availability is recorded for later requests
+		var res *http.Response
+		res, err = rt.try(host, g.config, r)
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			g.markUp(host)
+			return res, err
+		}
+		if r.Context().Err() == nil {
+			rt.markDown(g, host)
+		}
+		if !retry {
+			return nil, err
+		}
+	}
+
+	return nil, err
+}
+
+// route returns the physical hosts which may serve requests for host, in
+// the order they should be tried: the hosts in the group registered for host
+// using DialGroup, or host itself, followed by any backup hosts configured
+// by WithFailover.  If host names a group, the first members hosts belong
+// to group g.  If advance is set, the next request for a group using
+// round-robin starts with the following host.
+func (rt *RoundTripper) route(host string, advance bool) ([]string, *hostGroup, int) {
+	backups := rt.opts.failover[host]
+
+	g, ok := rt.group(host)
+	if !ok {
+		return append([]string{host}, backups...), nil, 0
+	}
+
+	hosts := g.candidates(advance)
+	members := len(hosts)
+	return append(hosts, backups...), g, members
+}
+
+// try attempts to serve r using host, dialing it with config if needed.  If
+// the connection to host is lost while serving r, it is removed from the
+// pool so that host is dialed again by the next request.
+func (rt *RoundTripper) try(host string, config *ssh.ClientConfig, r *http.Request) (*http.Response, error) {
+	p, err := rt.lazyDial(host, config)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := rt.serve(p, r)
+	if err != nil && isConnectionLost(err) {
+		rt.evict(host, p)
+	}
+
+	return res, err
+}
+
+// serve dispatches r to the appropriate handler for its HTTP method, using
+// the connection p.
+func (rt *RoundTripper) serve(p *clientPair, r *http.Request) (*http.Response, error) {
+	// Track this request as in flight until it returns; any response
+	// body streamed by rt.stream is tracked separately
+	p.inFlight.Add(1)
+	defer p.inFlight.Add(-1)
+
+	// Paths for Windows hosts may contain backslashes, which are
+	// converted to forward slashes before the request is served
+	if rt.opts.pathStyle == PathWindows {
+		u := *r.URL
+		u.Path = rt.opts.cleanPath(u.Path)
+		r = r.WithContext(r.Context())
+		r.URL = &u
+	}
+
 	switch r.Method {
 	// GET - retrieve a file's contents from the remote filesystem
 	case "GET":
-		return get(p, r)
+		return rt.get(p, r)
+	// PATCH - append to an existing file in the remote filesystem, if
+	// enabled
+	case "PATCH":
+		if rt.opts.appends {
+			return rt.patch(p, r)
+		}
+	// POST - run a command on the remote host, or upload files to the
+	// remote filesystem, if enabled
+	case "POST":
+		if rt.opts.execPath != "" && r.URL.Path == rt.opts.execPath {
+			return rt.exec(p, r)
+		}
+		if rt.opts.uploads && isUpload(r) {
+			return rt.upload(p, r)
+		}
+	// OPTIONS - describe the supported HTTP methods
+	case "OPTIONS":
+		h := http.Header{}
+		h.Set("Allow", strings.Join(rt.opts.methods(), ", "))
+		return rt.httpResponse(http.StatusNoContent, nil, h), nil
 	}
 
 	// Invalid HTTP method
-	return httpResponse(http.StatusMethodNotAllowed, nil, nil), nil
+	h := http.Header{}
+	h.Set("Allow", strings.Join(rt.opts.methods(), ", "))
+	return rt.httpResponse(http.StatusMethodNotAllowed, nil, h), nil
+}
+
+// methods returns the HTTP methods supported by a RoundTripper.
+func (o *options) methods() []string {
+	methods := []string{"GET", "OPTIONS"}
+	if o.appends {
+		methods = append(methods, "PATCH")
+	}
+	if o.execPath != "" || o.uploads {
+		methods = append(methods, "POST")
+	}
+
+	return methods
+}
+
+// cancelBody is an io.ReadCloser which invokes a context.CancelFunc when
+// it is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close implements io.Closer.
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
 }
 
-// lazyDial attempts to dial a connection to a host if one is not already
-// open.  If a connection is open, it returns that connection's clientPair.
-func (rt *RoundTripper) lazyDial(host string) (*clientPair, error) {
-	// Check for an existing, open connection
+// lazyDial attempts to dial a connection to a host using config if one is
+// not already open.  If config is nil, the configuration for the first
+// pattern registered using Dial which matches host is used, or the default
+// set by NewRoundTripper if none match.  If a connection is open, it returns
+// that connection's clientPair.
+// Connections which are known to be broken are closed and dialed again.
+func (rt *RoundTripper) lazyDial(host string, config *ssh.ClientConfig) (*clientPair, error) {
+	// Check for an existing, open connection, and mark it as used while
+	// holding the lock so it cannot be closed for being idle
+	rt.mu.RLock()
 	p, ok := rt.conn[host]
 	if ok {
-		return p, nil
+		p.lastUsed.Store(time.Now().UnixNano())
 	}
+	rt.mu.RUnlock()
+	if ok {
+		if !p.broken.Load() {
+			return p, nil
+		}
+
+		rt.evict(host, p)
+	}
+
+	// Dial a new connection, sharing the result with any concurrent
+	// callers which need the same host
+	v, err, _ := rt.dials.Do(host, func() (interface{}, error) {
+		// The host may have been dialed while waiting for a previous
+		// dial to finish
+		rt.mu.RLock()
+		p, ok := rt.conn[host]
+		rt.mu.RUnlock()
+		if ok && !p.broken.Load() {
+			return p, nil
+		}
 
-	// Dial a new connection using the default config
-	if err := rt.Dial(host, rt.config); err != nil {
+		if config == nil {
+			rt.mu.RLock()
+			config = rt.configs[host]
+			rt.mu.RUnlock()
+		}
+		if config == nil {
+			config = rt.patternConfig(host)
+		}
+		if config == nil {
+			config = rt.config
+		}
+
+		return rt.dial(host, config)
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	// Use the new connection for this RoundTrip
-	return rt.conn[host], nil
+	return v.(*clientPair), nil
+}
+
+// pair returns the connection to host for use by methods other than
+// RoundTrip, dialing host if needed.  Once shutdown begins, pair returns
+// ErrClosed.
+func (rt *RoundTripper) pair(host string) (*clientPair, error) {
+	rt.mu.RLock()
+	closing := rt.closing
+	rt.mu.RUnlock()
+	if closing {
+		return nil, ErrClosed
+	}
+
+	return rt.lazyDial(host, nil)
+}
+
+// hostPattern is a host pattern registered using Dial, and the SSH client
+// configuration used to dial hosts which match it.
+type hostPattern struct {
+	pattern string
+	config  *ssh.ClientConfig
+}
+
+// patternConfig returns the configuration for the first pattern which
+// matches host, or nil if none match.
+func (rt *RoundTripper) patternConfig(host string) *ssh.ClientConfig {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	for _, p := range rt.patterns {
+		if ok, _ := path.Match(p.pattern, host); ok {
+			return p.config
+		}
+	}
+
+	return nil
+}
+
+// evict removes the connection p for host from the pool and closes it, if
+// it has not already been evicted or replaced.
+func (rt *RoundTripper) evict(host string, p *clientPair) {
+	rt.mu.Lock()
+	ok := rt.conn[host] == p
+	if ok {
+		delete(rt.conn, host)
+	}
+	rt.mu.Unlock()
+
+	if ok {
+		_ = p.close()
+	}
+}
+
+// PoolStats contains a snapshot of statistics for a RoundTripper, returned
+// by its Stats method.
+type PoolStats struct {
+	// Connections is the number of open connections.
+	Connections int
+
+	// InFlight is the number of requests currently being served by each
+	// connected host.  A request is in flight until its response body has
+	// been completely transferred.
+	InFlight map[string]int64
+
+	// Requests is the total number of requests made using RoundTrip
+	// since the RoundTripper was created.  It is not reset when Close
+	// is called.
+	Requests int64
+
+	// BytesTransferred is the total number of response body bytes
+	// transferred since the RoundTripper was created.  It is not reset
+	// when Close is called.
+	BytesTransferred int64
+}
+
+// Stats returns a snapshot of statistics for this RoundTripper.  It is safe
+// to call Stats concurrently with RoundTrip, and the returned PoolStats is
+// not modified after it is returned.
+func (rt *RoundTripper) Stats() PoolStats {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	inFlight := make(map[string]int64, len(rt.conn))
+	for k, p := range rt.conn {
+		inFlight[k] = p.inFlight.Load()
+	}
+
+	return PoolStats{
+		Connections:      len(rt.conn),
+		InFlight:         inFlight,
+		Requests:         rt.requests.Load(),
+		BytesTransferred: rt.bytes.Load(),
+	}
+}
+
+// Hosts returns the hosts to which this RoundTripper currently holds an open
+// connection, sorted in lexical order.  It is safe to call Hosts
+// concurrently with RoundTrip.
+func (rt *RoundTripper) Hosts() []string {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	hosts := make([]string, 0, len(rt.conn))
+	for k := range rt.conn {
+		hosts = append(hosts, k)
+	}
+	sort.Strings(hosts)
+
+	return hosts
+}
+
+// SSHClient returns the SSH client used by this RoundTripper for host, if a
+// connection to host is open.  The client is owned by the RoundTripper, and
+// must not be closed by the caller.
+func (rt *RoundTripper) SSHClient(host string) (*ssh.Client, bool) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	p, ok := rt.conn[host]
+	if !ok {
+		return nil, false
+	}
+
+	return p.sshc, true
+}
+
+// SFTPClient returns the SFTP client used by this RoundTripper for host, if
+// a connection to host is open.  It may be used to perform operations which
+// RoundTripper does not expose, such as creating symbolic links.  The client
+// is owned by the RoundTripper, and must not be closed by the caller.
+func (rt *RoundTripper) SFTPClient(host string) (*sftp.Client, bool) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	p, ok := rt.conn[host]
+	if !ok {
+		return nil, false
+	}
+
+	return p.sftpc, true
 }
 
 // get attempts to retrieve a file from a remote filesystem over SSH, using SFTP
 // to return the file's contents in a HTTP response body.
-func get(p *clientPair, r *http.Request) (*http.Response, error) {
-	// Check for the requested file in the remote filesystem
-	f, err := p.sftpc.Open(r.URL.Path)
-	if err != nil {
-		serr, ok := err.(*sftp.StatusError)
-		if !ok {
-			return nil, err
-		}
+func (rt *RoundTripper) get(p *clientPair, r *http.Request) (*http.Response, error) {
+	// Ensure this path may be served, hiding or forbidding it if needed
+	if err := rt.opts.checkPath(r.URL.Path); err != nil {
+		return rt.errorResponse(r, err)
+	}
 
+	// Respond immediately if this path is already known not to exist
+	if p.missing != nil && p.missing.missing(r.URL.Path) {
+		return rt.errorResponse(r, os.ErrNotExist)
+	}
+
+	// Stat the file to retrieve size and modtime
+	var stat os.FileInfo
+	err := rt.opts.retry(r.Context(), func() (err error) {
+		stat, err = p.sftpc.Stat(r.URL.Path)
+		return err
+	})
+	if err != nil {
 		// If file does not exist, send a 404
-		if serr.Code == sftpNoSuchFile {
-			return httpResponse(http.StatusNotFound, nil, nil), nil
+		if os.IsNotExist(fsError(err)) {
+			if p.missing != nil {
+				p.missing.add(r.URL.Path)
+			}
+
+			return rt.errorResponse(r, os.ErrNotExist)
 		}
 
-		return nil, err
+		return rt.errorResponse(r, err)
 	}
 
-	// Stat the file to retrieve size and modtime
-	stat, err := f.Stat()
+	// Directories are served as listings or archives
+	if stat.IsDir() {
+		return rt.directory(p, r)
+	}
+
+	// Growing files are followed if enabled and requested
+	if rt.opts.followInterval > 0 && wantsFollow(r) {
+		return rt.unsized(p, r, stat, rt.opts.followInterval)
+	}
+
+	// Respond to a conditional request for an unmodified file before
+	// opening it, so no file handle or transfer is needed
+	if notModified(r, stat.ModTime()) {
+		h := http.Header{}
+		h.Set("Last-Modified", stat.ModTime().UTC().Format(http.TimeFormat))
+		return rt.httpResponse(http.StatusNotModified, nil, h), nil
+	}
+
+	// Files whose size is not known in advance, such as files in /proc
+	// which report no size, or files which are not regular files, are
+	// streamed without a Content-Length until their end is reached.  Empty
+	// files are detected and served without streaming.
+	if !stat.Mode().IsRegular() || stat.Size() == 0 {
+		return rt.unsized(p, r, stat, 0)
+	}
+
+	// Open the requested file in the remote filesystem, or reuse an open
+	// file from the cache if enabled
+	var f remoteFile
+	err = rt.opts.retry(r.Context(), func() error {
+		if p.handles != nil {
+			hf, release, err := p.handles.acquire(p.sftpc, r.URL.Path, stat)
+			if err != nil {
+				return err
+			}
+
+			f = &cachedFile{
+				SectionReader: io.NewSectionReader(hf, 0, stat.Size()),
+				release:       release,
+			}
+			return nil
+		}
+
+		sf, err := p.sftpc.Open(r.URL.Path)
+		if err != nil {
+			return err
+		}
+
+		f = sf
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return rt.errorResponse(r, err)
 	}
 
 	// Attach headers for file information
 	h := http.Header{}
-	h.Set("Content-Length", strconv.FormatInt(stat.Size(), 10))
-	h.Set("Last-Modified", stat.ModTime().UTC().Format(http.TimeFormat))
+
+	// Refuse or truncate files which exceed the maximum size, if set.  Only
+	// the first size bytes of a truncated file are served, but ranges and
+	// Content-Range still describe the entire file, so that clients cannot
+	// mistake the truncated content for the complete file.
+	total := stat.Size()
+	size := total
+	truncated := false
+	if limit := rt.opts.maxSize; limit > 0 && size > limit {
+		if !rt.opts.truncate {
+			_ = f.Close()
+			return rt.httpResponse(http.StatusRequestEntityTooLarge, nil, nil), nil
+		}
+
+		h.Set("X-Original-Content-Length", strconv.FormatInt(size, 10))
+		size = limit
+		truncated = true
+	}
+
+	h.Set("Content-Length", strconv.FormatInt(size, 10))
+
+	// Determine the file's content type
+	cType, err := rt.contentType(f, stat.Name())
+	if err != nil {
+		_ = f.Close()
+		return rt.errorResponse(r, err)
+	}
+	rt.setFileHeaders(h, r, stat, cType)
+
+	// Serve the ranges of the file which were requested.  A range which
+	// covers the entire file, such as a suffix range at least as large as
+	// the file, is served as a complete response, as are requests for too
+	// many ranges, or for overlapping ranges which exceed the size of the
+	// file.  The X-Max-Bytes header limits the requested ranges, or the
+	// entire file if no range was requested, so that a prefix of the file
+	// may be served as a preview.
+	code := http.StatusOK
+	h.Set("Accept-Ranges", "bytes")
+	limit, ok := maxBytes(r)
+	if !ok {
+		_ = f.Close()
+		return rt.httpResponse(http.StatusBadRequest, nil, nil), nil
+	}
+
+	// Ranges are ignored if the file has changed since the client
+	// retrieved the part it already has, as indicated by If-Range
+	rangeHeader := r.Header.Get("Range")
+	if !ifRange(r, stat.ModTime()) {
+		rangeHeader = ""
+	}
+	ranges, err := parseRanges(rangeHeader, total)
+	if err == nil && truncated && len(ranges) > 0 {
+		if ranges = clipRanges(ranges, size); len(ranges) == 0 {
+			err = errUnsatisfiableRange
+		}
+	}
+	if err == errUnsatisfiableRange {
+		_ = f.Close()
+
+		h := http.Header{}
+		h.Set("Content-Range", fmt.Sprintf("bytes */%d", total))
+		return rt.httpResponse(http.StatusRequestedRangeNotSatisfiable, nil, h), nil
+	}
+	if len(ranges) > maxRanges || sumRanges(ranges) > size {
+		ranges = nil
+	}
+	ranges = limitRanges(ranges, size, limit)
+
+	var body io.ReadCloser = f
+	switch {
+	case len(ranges) == 1 && ranges[0].length < total:
+		ra := ranges[0]
+		if _, err := f.Seek(ra.start, io.SeekStart); err != nil {
+			_ = f.Close()
+			return rt.errorResponse(r, err)
+		}
+
+		h.Set("Content-Range", ra.contentRange(total))
+		h.Set("Content-Length", strconv.FormatInt(ra.length, 10))
+		code = http.StatusPartialContent
+		size = ra.length
+	case len(ranges) > 1:
+		var mType string
+		body, size, mType = multipartRanges(f, ranges, total, cType)
+
+		h.Set("Content-Type", mType)
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+		code = http.StatusPartialContent
+	}
+
+	// Compute a digest of the file before sending the response if it is
+	// small enough and trailers are not requested, or while streaming it
+	// otherwise.  Digests describe the entire file, so they are omitted for
+	// partial content and truncated files.
+	var (
+		dt      *digestTrailer
+		trailer http.Header
+	)
+	if rt.opts.digest != "" && code == http.StatusOK && !truncated {
+		if !rt.opts.trailers && rt.opts.useEagerDigest(size) {
+			v, err := rt.opts.eagerDigest(f, size)
+			if err != nil {
+				_ = f.Close()
+				return rt.errorResponse(r, err)
+			}
+			h.Set(digestHeader(rt.opts.digest), v)
+		} else {
+			dt = rt.opts.newDigestTrailer(h)
+			trailer = dt.trailer
+		}
+	}
+	if rt.opts.trailers {
+		if trailer == nil {
+			trailer = http.Header{}
+		}
+
+		h.Add("Trailer", transferredBytesTrailer)
+		trailer[transferredBytesTrailer] = nil
+	}
+
+	// Stream the file from disk to the HTTP response
+	pr := rt.stream(r.Context(), p, func(ctx context.Context, w io.Writer) error {
+		cw := &countWriter{w: w}
+		var dst io.Writer = cw
+		if dt != nil {
+			dst = io.MultiWriter(cw, dt)
+		}
+
+		if err := rt.opts.copyN(ctx, dst, body, size); err != nil {
+			return err
+		}
+
+		if dt != nil {
+			dt.finish()
+		}
+		if rt.opts.trailers {
+			trailer.Set(transferredBytesTrailer, strconv.FormatInt(cw.n, 10))
+		}
+		return nil
+	})
+
+	// Send HTTP response with code, pipe reader body, and headers
+	res := rt.httpResponse(
+		code,
+		pr,
+		h,
+	)
+	res.Trailer = trailer
+
+	return res, nil
+}
+
+// setFileInfoHeaders applies headers describing the permissions and
+// ownership of the file described by fi to h.
+func setFileInfoHeaders(h http.Header, fi os.FileInfo) {
+	h.Set("X-File-Mode", fmt.Sprintf("%#o", fi.Mode().Perm()))
+
+	// Ownership is only available if reported by the remote host
+	if st, ok := fi.Sys().(*sftp.FileStat); ok {
+		h.Set("X-File-Uid", strconv.FormatUint(uint64(st.UID), 10))
+		h.Set("X-File-Gid", strconv.FormatUint(uint64(st.GID), 10))
+	}
+}
+
+// notModified reports whether r is a conditional request using the
+// If-Modified-Since header, and a file with modification time modTime has
+// not been modified since the time specified.
+func notModified(r *http.Request, modTime time.Time) bool {
+	t, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	// HTTP dates have a resolution of one second
+	return !modTime.Truncate(time.Second).After(t)
+}
+
+// setFileHeaders sets the headers which describe the file fi, served for r
+// with content type cType, for both sized and unsized responses.
+func (rt *RoundTripper) setFileHeaders(h http.Header, r *http.Request, fi os.FileInfo, cType string) {
+	h.Set("Content-Type", cType)
+	h.Set("Last-Modified", fi.ModTime().UTC().Format(http.TimeFormat))
+	h.Set("ETag", etag(fi))
+	if rt.opts.fileInfoHeaders {
+		setFileInfoHeaders(h, fi)
+	}
+	rt.opts.setCacheHeaders(h, r.URL.Path, cType)
+
+	// Force the client to download the file instead of displaying it, if
+	// configured or requested
+	if rt.opts.forceDownload || wantsDownload(r) {
+		h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": fi.Name(),
+		}))
+	}
+}
+
+// wantsDownload reports whether r requests that a file be downloaded as an
+// attachment, using the download query parameter, such as ?download=1.
+func wantsDownload(r *http.Request) bool {
+	ok, err := strconv.ParseBool(r.URL.Query().Get("download"))
+	return err == nil && ok
+}
+
+// contentType determines the content type of the file f with base name
+// name.  A resolver set using WithMIMEResolver takes precedence, followed by
+// the file's extension, and finally by sniffing the beginning of the file.
+// If sniffing is disabled, the resolver is passed no file contents, and
+// application/octet-stream is used when the other methods fail.
+func (rt *RoundTripper) contentType(f remoteFile, name string) (string, error) {
+	var head []byte
+	if fn := rt.opts.mimeResolver; fn != nil {
+		// The resolver is passed no contents if sniffing is disabled
+		if !rt.opts.noSniff {
+			b, err := rt.sniff(f)
+			if err != nil {
+				return "", err
+			}
+			head = b
+		}
+
+		if cType := fn(name, head); cType != "" {
+			return cType, nil
+		}
+	}
 
 	// Attempt to discover Content-Type using file extension
-	cType := mime.TypeByExtension(filepath.Ext(stat.Name()))
-	if cType != "" {
-		h.Set("Content-Type", cType)
-	} else {
-		// As a fallback, read the first 512 bytes of the file
-		// to determine its content type
-		buf := bytes.NewBuffer(nil)
-		rn, err := io.CopyN(buf, f, 512)
+	if cType := mime.TypeByExtension(path.Ext(name)); cType != "" {
+		return cType, nil
+	}
+
+	if rt.opts.noSniff {
+		return rt.opts.contentType(), nil
+	}
+
+	// As a fallback, sniff the beginning of the file, if it was not
+	// already read for the resolver
+	if head == nil {
+		b, err := rt.sniff(f)
 		if err != nil {
-			return nil, err
+			return "", err
 		}
-		h.Set("Content-Type", http.DetectContentType(buf.Bytes()[:rn]))
+		head = b
+	}
 
-		// Rewind file so the entire file can be transferred
-		if _, err := f.Seek(0, os.SEEK_SET); err != nil {
-			return nil, err
-		}
+	return http.DetectContentType(head), nil
+}
+
+// sniff reads the beginning of f for content type detection.  Files shorter
+// than the sniff length are read in their entirety.  The file is read using
+// ReadAt, so its offset is not modified, and the entire file can still be
+// transferred.
+func (rt *RoundTripper) sniff(f remoteFile) ([]byte, error) {
+	buf := make([]byte, rt.opts.sniffLength())
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
 	}
 
-	// Open an in-memory pipe to stream the file from disk to the HTTP response
+	return buf[:n], nil
+}
+
+// remoteFile is a remote file which is being served by get.
+type remoteFile interface {
+	io.ReadCloser
+	io.ReaderAt
+	io.Seeker
+}
+
+// stream invokes fn in a new goroutine, and returns an in-memory pipe which
+// can be used to read the data fn writes as a HTTP response body.  Any error
+// returned by fn is sent to the reader of the pipe.  The transfer is tracked
+// as in flight using p until fn returns.
+//
+// The context passed to fn is canceled if ctx is canceled, or if the pipe is
+// closed by its reader, such as when a client disconnects.  The pipe is then
+// closed, causing any further writes by fn to fail so that the transfer is
+// aborted, and fn should stop reading from SFTP as soon as possible.
+func (rt *RoundTripper) stream(ctx context.Context, p *clientPair, fn func(ctx context.Context, w io.Writer) error) io.ReadCloser {
+	p.inFlight.Add(1)
+	rt.wg.Add(1)
+
+	ctx, cancel := context.WithCancel(ctx)
 	pr, pw := io.Pipe()
 	go func() {
-		// Transfer file bytes and clean up
-		var sErr stickyError
-		_, err := io.CopyN(pw, f, stat.Size())
-		sErr.Set(err)
-		sErr.Set(f.Close())
+		defer rt.wg.Done()
+		defer p.inFlight.Add(-1)
+		defer cancel()
+
+		stop := context.AfterFunc(ctx, func() {
+			_ = pw.CloseWithError(ctx.Err())
+		})
+		defer stop()
+
+		cw := &countWriter{w: rt.opts.limitWriter(ctx, pw)}
+		err := fn(ctx, cw)
+		rt.bytes.Add(cw.n)
 
 		// Send any errors during streaming or cleanup to the client
 		// This method always returns nil error.
-		_ = pw.CloseWithError(sErr.Get())
+		_ = pw.CloseWithError(err)
 	}()
 
-	// Send HTTP response with code, pipe reader body, and headers
-	return httpResponse(
-		http.StatusOK,
-		pr,
-		h,
-	), nil
+	return &cancelBody{
+		ReadCloser: pr,
+		cancel:     cancel,
+	}
+}
+
+// copyN copies n bytes from the remote file f to w, and closes f.  If ctx is
+// canceled, f is closed immediately, so that any pending read is aborted
+// instead of needlessly transferring data over SFTP.  A pooled buffer is
+// used for the copy if configured by WithBufferSize.
+func (o *options) copyN(ctx context.Context, w io.Writer, f io.ReadCloser, n int64) error {
+	stop := context.AfterFunc(ctx, func() {
+		_ = f.Close()
+	})
+
+	buf, put := o.buffer()
+	defer put()
+
+	// Like io.CopyN, report a short copy as io.EOF
+	written, err := io.CopyBuffer(w, io.LimitReader(f, n), buf)
+	if err == nil && written < n {
+		err = io.EOF
+	}
+	if !stop() {
+		// f was already closed due to cancelation, so report that
+		// as the cause of any failure
+		return ctx.Err()
+	}
+
+	var sErr stickyError
+	sErr.Set(err)
+	sErr.Set(f.Close())
+
+	return sErr.Get()
+}
+
+// buffer returns a buffer from the pool configured by WithBufferSize, and a
+// function which returns it to the pool once it is no longer used.  If no
+// pool is configured, the buffer is nil.
+func (o *options) buffer() ([]byte, func()) {
+	if o.buffers == nil {
+		return nil, func() {}
+	}
+
+	b := o.buffers.Get().(*[]byte)
+	return *b, func() { o.buffers.Put(b) }
+}
+
+// countWriter is an io.Writer which counts the number of bytes written
+// to its underlying io.Writer.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements io.Writer.
+func (w *countWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+// errorResponse converts an error which occurred while serving r into a HTTP
+// response, so that failures reported by the remote host can be handled by
+// clients in the same way as any other HTTP error.  Errors are mapped to HTTP
+// status codes as follows:
+//   - os.ErrNotExist: 404 Not Found, or an error if WithNotFoundError is set
+//   - os.ErrPermission: 403 Forbidden
+//   - ErrFileTooLarge: 413 Request Entity Too Large
+//   - context.DeadlineExceeded: 504 Gateway Timeout
+//   - any other SFTP status error: 502 Bad Gateway
+//
+// The body of each response describes the error.  Any other error, such as a
+// lost connection or a canceled request, indicates a transport-level problem,
+// and is returned as-is so that RoundTrip fails, or fails over to another
+// host, if configured.
+func (rt *RoundTripper) errorResponse(r *http.Request, err error) (*http.Response, error) {
+	// Deadlines are checked first, as context.DeadlineExceeded is also a
+	// transient timeout error
+	if !errors.Is(err, context.DeadlineExceeded) && isTransient(err) {
+		return nil, err
+	}
+
+	var code int
+	var serr *sftp.StatusError
+	switch ferr := fsError(err); {
+	case errors.Is(err, context.DeadlineExceeded):
+		code = http.StatusGatewayTimeout
+	case os.IsNotExist(ferr):
+		if rt.opts.notFoundError {
+			return nil, &os.PathError{
+				Op:   r.Method,
+				Path: r.URL.Path,
+				Err:  os.ErrNotExist,
+			}
+		}
+
+		code = http.StatusNotFound
+	case os.IsPermission(ferr):
+		code = http.StatusForbidden
+	case errors.Is(err, ErrFileTooLarge):
+		code = http.StatusRequestEntityTooLarge
+	case errors.As(err, &serr):
+		code = http.StatusBadGateway
+	default:
+		return nil, err
+	}
+
+	body := err.Error() + "\n"
+	h := http.Header{}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return rt.httpResponse(code, io.NopCloser(strings.NewReader(body)), h), nil
 }
 
 // httpResponse builds a HTTP response with typical headers using an input
-// HTTP status code, response body, and initial HTTP headers.
-func httpResponse(code int, body io.ReadCloser, headers http.Header) *http.Response {
+// HTTP status code, response body, and initial HTTP headers.  If body is nil
+// for an error status, a short body describing the status is sent instead.
+func (rt *RoundTripper) httpResponse(code int, body io.ReadCloser, headers http.Header) *http.Response {
 	res := &http.Response{
 		StatusCode: code,
+		Proto:      "HTTP/1.1",
 		ProtoMajor: 1,
 		ProtoMinor: 1,
 
 		Body: body,
 	}
 
-	// Apply parameter headers and identify server
+	// Apply parameter headers and identify server, unless configured
+	// to omit the Server header
 	h := http.Header{}
-	h.Set("Server", "github.com/mdlayher/sshttp")
+	if body == nil && code >= http.StatusBadRequest {
+		// Describe errors with a short explanatory body, which may be
+		// displayed to users
+		msg := strconv.Itoa(code) + " " + http.StatusText(code) + "\n"
+		body = io.NopCloser(strings.NewReader(msg))
+		h.Set("Content-Length", strconv.Itoa(len(msg)))
+		res.Body = body
+	}
+	if server := rt.opts.server(); server != "" {
+		h.Set("Server", server)
+	}
 	for k, v := range headers {
 		for _, vv := range v {
 			h.Add(k, vv)
 		}
 	}
 
+	// Apply headers configured using WithHeaders, if they do not already
+	// exist
+	for k, v := range rt.opts.headers {
+		k = http.CanonicalHeaderKey(k)
+		if k == "Server" || len(h[k]) > 0 {
+			continue
+		}
+
+		h[k] = append([]string(nil), v...)
+	}
+
 	// Apply defaults for headers, if they do not already exist
 
 	const date = "Date"
@@ -226,11 +1272,27 @@ func httpResponse(code int, body io.ReadCloser, headers http.Header) *http.Respo
 		h.Set(contentType, "text/plain; charset=utf-8")
 	}
 
+	// Responses other than 200 OK close the connection unless configured
+	// otherwise, except for 304 Not Modified, which is safe to reuse
 	const connection = "Connection"
-	if code != http.StatusOK && h.Get(connection) == "" {
+	autoClose := !rt.opts.noAutoClose && code != http.StatusOK && code != http.StatusNotModified
+	if autoClose && h.Get(connection) == "" {
 		h.Set(connection, "close")
 	}
 
+	// Populate response fields which describe framing and connection reuse,
+	// so that net/http's client need not rely on the headers alone
+	res.ContentLength = -1
+	if body == nil {
+		res.Body = http.NoBody
+		res.ContentLength = 0
+	} else if n, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64); err == nil {
+		res.ContentLength = n
+	} else {
+		res.TransferEncoding = []string{"chunked"}
+	}
+	res.Close = h.Get(connection) == "close"
+
 	res.Header = h
 	return res
 }
@@ -0,0 +1,1314 @@
+package sshttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// newRequest creates a HTTP request for a test.
+func newRequest(t testing.TB, method string, url string) *http.Request {
+	t.Helper()
+
+	r, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+
+	return r
+}
+
+// do performs the request r using a http.Client backed by rt, and returns
+// the response along with its entire body.
+func do(t testing.TB, rt *RoundTripper, r *http.Request) (*http.Response, []byte) {
+	t.Helper()
+
+	res, err := (&http.Client{Transport: rt}).Do(r)
+	if err != nil {
+		t.Fatalf("failed to perform request: %v", err)
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+
+	return res, b
+}
+
+// testFile returns n bytes of deterministic file contents.
+func testFile(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte('a' + i%26)
+	}
+
+	return b
+}
+
+func TestRoundTripperGetSniffedFile(t *testing.T) {
+	// Sniffing reads the beginning of the file, which must not prevent it
+	// from being streamed in its entirety
+	file := append([]byte("<!DOCTYPE html>\n"), testFile(100*1024)...)
+
+	s := newTestServer(t)
+	s.writeFile(t, "index", file)
+
+	rt := newTestRoundTripper(t)
+	res, body := do(t, rt, newRequest(t, http.MethodGet, s.url("index")))
+
+	if want, got := http.StatusOK, res.StatusCode; want != got {
+		t.Fatalf("unexpected status code: %v != %v", want, got)
+	}
+	if want, got := "text/html; charset=utf-8", res.Header.Get("Content-Type"); want != got {
+		t.Fatalf("unexpected Content-Type: %q != %q", want, got)
+	}
+	if want, got := strconv.Itoa(len(file)), res.Header.Get("Content-Length"); want != got {
+		t.Fatalf("unexpected Content-Length: %v != %v", want, got)
+	}
+	if !bytes.Equal(file, body) {
+		t.Fatalf("unexpected body: %d bytes != %d bytes", len(file), len(body))
+	}
+}
+
+func TestRoundTripperGetSniffingDisabled(t *testing.T) {
+	file := []byte("<!DOCTYPE html>\n<p>hello</p>\n")
+
+	s := newTestServer(t)
+	s.writeFile(t, "index", file)
+	s.writeFile(t, "index.txt", file)
+
+	// Resolvers are passed no file contents when sniffing is disabled
+	var (
+		calls int
+		head  []byte
+	)
+	resolver := func(_ string, b []byte) string {
+		calls++
+		head = b
+		return ""
+	}
+
+	var tests = []struct {
+		desc  string
+		opts  []Option
+		name  string
+		cType string
+	}{
+		{
+			desc:  "default type",
+			name:  "index",
+			cType: "application/octet-stream",
+		},
+		{
+			desc:  "configured default type",
+			opts:  []Option{WithDefaultContentType("text/plain")},
+			name:  "index",
+			cType: "text/plain",
+		},
+		{
+			desc:  "extension",
+			name:  "index.txt",
+			cType: "text/plain; charset=utf-8",
+		},
+		{
+			desc:  "resolver",
+			opts:  []Option{WithMIMEResolver(resolver)},
+			name:  "index",
+			cType: "application/octet-stream",
+		},
+	}
+
+	for i, tt := range tests {
+		rt := newTestRoundTripper(t, append(tt.opts, WithContentTypeSniffing(false))...)
+		res, body := do(t, rt, newRequest(t, http.MethodGet, s.url(tt.name)))
+
+		if want, got := http.StatusOK, res.StatusCode; want != got {
+			t.Fatalf("[%02d] test %q, unexpected status code: %v != %v",
+				i, tt.desc, want, got)
+		}
+		if want, got := tt.cType, res.Header.Get("Content-Type"); want != got {
+			t.Fatalf("[%02d] test %q, unexpected Content-Type: %q != %q",
+				i, tt.desc, want, got)
+		}
+		if !bytes.Equal(file, body) {
+			t.Fatalf("[%02d] test %q, unexpected body: %q != %q",
+				i, tt.desc, file, body)
+		}
+	}
+
+	if want, got := 1, calls; want != got {
+		t.Fatalf("unexpected number of resolver calls: %v != %v", want, got)
+	}
+	if len(head) != 0 {
+		t.Fatalf("resolver was passed file contents: %q", head)
+	}
+}
+
+func TestRoundTripperGetEmptyFile(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "empty", nil)
+
+	rt := newTestRoundTripper(t)
+	res, err := (&http.Client{Transport: rt}).Get(s.url("empty") + "?download=1")
+	if err != nil {
+		t.Fatalf("failed to perform request: %v", err)
+	}
+	defer res.Body.Close()
+
+	// No transfer is in flight for an empty file, even before its body
+	// is closed
+	if want, got := int64(0), rt.Stats().InFlight[s.addr]; want != got {
+		t.Fatalf("unexpected number of transfers in flight: %v != %v", want, got)
+	}
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+
+	if want, got := http.StatusOK, res.StatusCode; want != got {
+		t.Fatalf("unexpected status code: %v != %v", want, got)
+	}
+	if want, got := "0", res.Header.Get("Content-Length"); want != got {
+		t.Fatalf("unexpected Content-Length: %q != %q", want, got)
+	}
+	if want, got := 0, len(b); want != got {
+		t.Fatalf("unexpected body length: %v != %v", want, got)
+	}
+	if want, got := `attachment; filename=empty`, res.Header.Get("Content-Disposition"); want != got {
+		t.Fatalf("unexpected Content-Disposition: %q != %q", want, got)
+	}
+}
+
+func TestRoundTripperGetDownload(t *testing.T) {
+	var tests = []struct {
+		desc  string
+		name  string
+		query string
+		opts  []Option
+		want  string
+	}{
+		{
+			desc: "inline",
+			name: "report.pdf",
+		},
+		{
+			desc:  "ASCII query",
+			name:  "report.pdf",
+			query: "?download=1",
+			want:  "report.pdf",
+		},
+		{
+			desc:  "UTF-8 query",
+			name:  "résumé.pdf",
+			query: "?download=true",
+			want:  "résumé.pdf",
+		},
+		{
+			desc: "forced",
+			name: "日本語.txt",
+			opts: []Option{WithForceDownload(true)},
+			want: "日本語.txt",
+		},
+	}
+
+	s := newTestServer(t)
+
+	for i, tt := range tests {
+		s.writeFile(t, tt.name, []byte("hello"))
+
+		rt := newTestRoundTripper(t, tt.opts...)
+		res, _ := do(t, rt, newRequest(t, http.MethodGet, s.url(tt.name)+tt.query))
+
+		cd := res.Header.Get("Content-Disposition")
+		if tt.want == "" {
+			if cd != "" {
+				t.Fatalf("[%02d] test %q, unexpected Content-Disposition: %q",
+					i, tt.desc, cd)
+			}
+
+			continue
+		}
+
+		disposition, params, err := mime.ParseMediaType(cd)
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to parse Content-Disposition %q: %v",
+				i, tt.desc, cd, err)
+		}
+		if want, got := "attachment", disposition; want != got {
+			t.Fatalf("[%02d] test %q, unexpected disposition: %q != %q",
+				i, tt.desc, want, got)
+		}
+		if want, got := tt.want, params["filename"]; want != got {
+			t.Fatalf("[%02d] test %q, unexpected filename: %q != %q",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestRoundTripperGetRanges(t *testing.T) {
+	file := testFile(1000)
+
+	var tests = []struct {
+		desc   string
+		header string
+		code   int
+		cRange string
+		body   []byte
+	}{
+		{
+			desc:   "bounded",
+			header: "bytes=10-19",
+			code:   http.StatusPartialContent,
+			cRange: "bytes 10-19/1000",
+			body:   file[10:20],
+		},
+		{
+			desc:   "suffix",
+			header: "bytes=-500",
+			code:   http.StatusPartialContent,
+			cRange: "bytes 500-999/1000",
+			body:   file[500:],
+		},
+		{
+			desc:   "open-ended",
+			header: "bytes=900-",
+			code:   http.StatusPartialContent,
+			cRange: "bytes 900-999/1000",
+			body:   file[900:],
+		},
+		{
+			desc:   "clamped suffix",
+			header: "bytes=-5000",
+			code:   http.StatusOK,
+			body:   file,
+		},
+		{
+			desc:   "clamped end",
+			header: "bytes=990-5000",
+			code:   http.StatusPartialContent,
+			cRange: "bytes 990-999/1000",
+			body:   file[990:],
+		},
+		{
+			desc:   "unsatisfiable",
+			header: "bytes=1000-",
+			code:   http.StatusRequestedRangeNotSatisfiable,
+			cRange: "bytes */1000",
+		},
+	}
+
+	s := newTestServer(t)
+	s.writeFile(t, "file", file)
+	rt := newTestRoundTripper(t)
+
+	for i, tt := range tests {
+		r := newRequest(t, http.MethodGet, s.url("file"))
+		r.Header.Set("Range", tt.header)
+		res, body := do(t, rt, r)
+
+		if want, got := tt.code, res.StatusCode; want != got {
+			t.Fatalf("[%02d] test %q, unexpected status code: %v != %v",
+				i, tt.desc, want, got)
+		}
+		if want, got := tt.cRange, res.Header.Get("Content-Range"); want != got {
+			t.Fatalf("[%02d] test %q, unexpected Content-Range: %q != %q",
+				i, tt.desc, want, got)
+		}
+		if tt.body == nil {
+			continue
+		}
+		if !bytes.Equal(tt.body, body) {
+			t.Fatalf("[%02d] test %q, unexpected body: %q != %q",
+				i, tt.desc, tt.body, body)
+		}
+	}
+}
+
+func TestRoundTripperGetMaxBytes(t *testing.T) {
+	file := testFile(100)
+
+	var tests = []struct {
+		desc   string
+		limit  string
+		rng    string
+		code   int
+		cRange string
+		body   []byte
+	}{
+		{
+			desc:   "prefix",
+			limit:  "10",
+			code:   http.StatusPartialContent,
+			cRange: "bytes 0-9/100",
+			body:   file[:10],
+		},
+		{
+			desc:  "larger than file",
+			limit: "1000",
+			code:  http.StatusOK,
+			body:  file,
+		},
+		{
+			desc:   "limits range",
+			limit:  "5",
+			rng:    "bytes=50-",
+			code:   http.StatusPartialContent,
+			cRange: "bytes 50-54/100",
+			body:   file[50:55],
+		},
+		{
+			desc:  "malformed",
+			limit: "-1",
+			code:  http.StatusBadRequest,
+		},
+	}
+
+	s := newTestServer(t)
+	s.writeFile(t, "file", file)
+	rt := newTestRoundTripper(t)
+
+	for i, tt := range tests {
+		r := newRequest(t, http.MethodGet, s.url("file"))
+		r.Header.Set(maxBytesHeader, tt.limit)
+		if tt.rng != "" {
+			r.Header.Set("Range", tt.rng)
+		}
+		res, body := do(t, rt, r)
+
+		if want, got := tt.code, res.StatusCode; want != got {
+			t.Fatalf("[%02d] test %q, unexpected status code: %v != %v",
+				i, tt.desc, want, got)
+		}
+		if tt.body == nil {
+			continue
+		}
+
+		if want, got := tt.cRange, res.Header.Get("Content-Range"); want != got {
+			t.Fatalf("[%02d] test %q, unexpected Content-Range: %q != %q",
+				i, tt.desc, want, got)
+		}
+		if want, got := strconv.Itoa(len(tt.body)), res.Header.Get("Content-Length"); want != got {
+			t.Fatalf("[%02d] test %q, unexpected Content-Length: %v != %v",
+				i, tt.desc, want, got)
+		}
+		if !bytes.Equal(tt.body, body) {
+			t.Fatalf("[%02d] test %q, unexpected body: %q != %q",
+				i, tt.desc, tt.body, body)
+		}
+	}
+}
+
+func TestRoundTripperGetTrailers(t *testing.T) {
+	file := testFile(64 * 1024)
+	sum := sha256.Sum256(file)
+
+	s := newTestServer(t)
+	s.writeFile(t, "file", file)
+
+	rt := newTestRoundTripper(t, WithDigest("sha-256"), WithTrailers(true))
+	res, err := (&http.Client{Transport: rt}).Get(s.url("file"))
+	if err != nil {
+		t.Fatalf("failed to perform request: %v", err)
+	}
+	defer res.Body.Close()
+
+	// Trailers are declared up front, but only populated once the body
+	// has been read
+	if _, ok := res.Trailer[transferredBytesTrailer]; !ok {
+		t.Fatalf("trailer %q was not declared", transferredBytesTrailer)
+	}
+	if _, err := io.Copy(io.Discard, res.Body); err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+
+	if want, got := strconv.Itoa(len(file)), res.Trailer.Get(transferredBytesTrailer); want != got {
+		t.Fatalf("unexpected transferred bytes: %q != %q", want, got)
+	}
+
+	want := "sha-256=" + base64.StdEncoding.EncodeToString(sum[:])
+	if got := res.Trailer.Get("Digest"); want != got {
+		t.Fatalf("unexpected digest: %q != %q", want, got)
+	}
+}
+
+func TestRoundTripperGetTruncated(t *testing.T) {
+	file := testFile(100)
+
+	var tests = []struct {
+		desc   string
+		rng    string
+		limit  string
+		code   int
+		cRange string
+		body   []byte
+	}{
+		{
+			desc: "entire file",
+			code: http.StatusOK,
+			body: file[:40],
+		},
+		{
+			desc:   "range",
+			rng:    "bytes=10-19",
+			code:   http.StatusPartialContent,
+			cRange: "bytes 10-19/100",
+			body:   file[10:20],
+		},
+		{
+			desc:   "range crossing limit",
+			rng:    "bytes=30-",
+			code:   http.StatusPartialContent,
+			cRange: "bytes 30-39/100",
+			body:   file[30:40],
+		},
+		{
+			desc:   "range beyond limit",
+			rng:    "bytes=50-",
+			code:   http.StatusRequestedRangeNotSatisfiable,
+			cRange: "bytes */100",
+		},
+		{
+			desc:   "X-Max-Bytes beyond limit",
+			limit:  "100",
+			code:   http.StatusPartialContent,
+			cRange: "bytes 0-39/100",
+			body:   file[:40],
+		},
+	}
+
+	s := newTestServer(t)
+	s.writeFile(t, "file", file)
+	rt := newTestRoundTripper(t,
+		WithMaxFileSize(40, true),
+		WithDigest("sha-256"),
+	)
+
+	for i, tt := range tests {
+		r := newRequest(t, http.MethodGet, s.url("file"))
+		if tt.rng != "" {
+			r.Header.Set("Range", tt.rng)
+		}
+		if tt.limit != "" {
+			r.Header.Set(maxBytesHeader, tt.limit)
+		}
+		res, body := do(t, rt, r)
+
+		if want, got := tt.code, res.StatusCode; want != got {
+			t.Fatalf("[%02d] test %q, unexpected status code: %v != %v",
+				i, tt.desc, want, got)
+		}
+		if want, got := tt.cRange, res.Header.Get("Content-Range"); want != got {
+			t.Fatalf("[%02d] test %q, unexpected Content-Range: %q != %q",
+				i, tt.desc, want, got)
+		}
+		if tt.body == nil {
+			continue
+		}
+
+		if want, got := "100", res.Header.Get("X-Original-Content-Length"); want != got {
+			t.Fatalf("[%02d] test %q, unexpected original length: %q != %q",
+				i, tt.desc, want, got)
+		}
+		if !bytes.Equal(tt.body, body) {
+			t.Fatalf("[%02d] test %q, unexpected body: %q != %q",
+				i, tt.desc, tt.body, body)
+		}
+
+		// Digests of the entire file cannot describe a truncated body
+		if v := res.Header.Get("Digest"); v != "" {
+			t.Fatalf("[%02d] test %q, unexpected digest: %q", i, tt.desc, v)
+		}
+		if v := res.Trailer.Get("Digest"); v != "" {
+			t.Fatalf("[%02d] test %q, unexpected digest trailer: %q", i, tt.desc, v)
+		}
+	}
+}
+
+func TestRoundTripperCloseBodyAbortsTransfer(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "file", testFile(8*1024*1024))
+
+	// Limit the transfer rate so the transfer cannot complete on its own
+	// before the body is closed
+	rt := NewRoundTripper(testClientConfig(), WithRateLimit(64*1024))
+	res, err := (&http.Client{Transport: rt}).Get(s.url("file"))
+	if err != nil {
+		t.Fatalf("failed to perform request: %v", err)
+	}
+
+	if _, err := io.ReadFull(res.Body, make([]byte, 1024)); err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	_ = res.Body.Close()
+
+	// Shutdown waits for the transfer, which must stop promptly
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := rt.Shutdown(ctx); err != nil {
+		t.Fatalf("transfer was not aborted: %v", err)
+	}
+}
+
+func TestRoundTripperShutdownWaitsForTransfers(t *testing.T) {
+	file := testFile(1024 * 1024)
+
+	s := newTestServer(t)
+	s.writeFile(t, "file", file)
+
+	rt := NewRoundTripper(testClientConfig())
+	res, err := (&http.Client{Transport: rt}).Get(s.url("file"))
+	if err != nil {
+		t.Fatalf("failed to perform request: %v", err)
+	}
+	defer res.Body.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- rt.Shutdown(context.Background())
+	}()
+
+	// New requests are refused while shutting down, but the active
+	// transfer is allowed to finish
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, err := rt.RoundTrip(newRequest(t, http.MethodGet, s.url("file")))
+		if errors.Is(err, ErrClosed) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_ = res.Body.Close()
+
+		if time.Now().After(deadline) {
+			t.Fatal("RoundTripper did not begin shutting down")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned before transfer finished: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	if !bytes.Equal(file, b) {
+		t.Fatalf("unexpected body: %d bytes != %d bytes", len(file), len(b))
+	}
+
+	_ = res.Body.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("failed to shut down: %v", err)
+	}
+}
+
+func TestRoundTripperConcurrentDialsOnce(t *testing.T) {
+	const n = 32
+
+	s := newTestServer(t)
+	s.writeFile(t, "file", []byte("hello"))
+
+	rt := newTestRoundTripper(t)
+	c := &http.Client{Transport: rt}
+
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, n)
+	)
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			res, err := c.Get(s.url("file"))
+			if err != nil {
+				errs <- err
+				return
+			}
+			_, _ = io.Copy(io.Discard, res.Body)
+			_ = res.Body.Close()
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("failed to perform request: %v", err)
+	}
+	if want, got := int32(1), s.dials.Load(); want != got {
+		t.Fatalf("unexpected number of dials: %v != %v", want, got)
+	}
+}
+
+func TestRoundTripperFailover(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "file", []byte("hello"))
+
+	// Reserve an address which nothing is listening on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	primary := l.Addr().String()
+	_ = l.Close()
+
+	rt := newTestRoundTripper(t, WithFailover(primary, s.addr))
+
+	u := fmt.Sprintf("%s://%s%s", Protocol, primary, s.path("file"))
+	res, body := do(t, rt, newRequest(t, http.MethodGet, u))
+
+	if want, got := http.StatusOK, res.StatusCode; want != got {
+		t.Fatalf("unexpected status code: %v != %v", want, got)
+	}
+	if want, got := "hello", string(body); want != got {
+		t.Fatalf("unexpected body: %q != %q", want, got)
+	}
+
+	// Missing files are reported by the backup, rather than causing
+	// another host to be tried
+	res, _ = do(t, rt, newRequest(t, http.MethodGet, u+".missing"))
+	if want, got := http.StatusNotFound, res.StatusCode; want != got {
+		t.Fatalf("unexpected status code: %v != %v", want, got)
+	}
+}
+
+func TestRoundTripperRedialsLostConnection(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "file", []byte("hello"))
+
+	rt := newTestRoundTripper(t)
+	if res, _ := do(t, rt, newRequest(t, http.MethodGet, s.url("file"))); res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: %v", res.StatusCode)
+	}
+
+	// Once the connection is lost, at most one request may fail before
+	// the host is dialed again
+	s.closeConns()
+
+	var failed int
+	for i := 0; ; i++ {
+		res, err := rt.RoundTrip(newRequest(t, http.MethodGet, s.url("file")))
+		if err == nil {
+			_ = res.Body.Close()
+			if want, got := http.StatusOK, res.StatusCode; want != got {
+				t.Fatalf("unexpected status code: %v != %v", want, got)
+			}
+
+			break
+		}
+
+		failed++
+		if i >= 50 {
+			t.Fatalf("failed to redial after lost connection: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if failed > 1 {
+		t.Fatalf("too many failed requests after lost connection: %d", failed)
+	}
+	if want, got := int32(2), s.dials.Load(); want != got {
+		t.Fatalf("unexpected number of dials: %v != %v", want, got)
+	}
+}
+
+func TestRoundTripperErrorResponse(t *testing.T) {
+	var tests = []struct {
+		desc string
+		err  error
+		code int
+	}{
+		{
+			desc: "not exist",
+			err:  &sftp.StatusError{Code: sftpNoSuchFile},
+			code: http.StatusNotFound,
+		},
+		{
+			desc: "permission denied",
+			err:  &sftp.StatusError{Code: sftpPermissionDenied},
+			code: http.StatusForbidden,
+		},
+		{
+			desc: "file too large",
+			err:  ErrFileTooLarge,
+			code: http.StatusRequestEntityTooLarge,
+		},
+		{
+			desc: "deadline exceeded",
+			err:  fmt.Errorf("open: %w", context.DeadlineExceeded),
+			code: http.StatusGatewayTimeout,
+		},
+		{
+			desc: "other SFTP status",
+			err:  &sftp.StatusError{Code: 4},
+			code: http.StatusBadGateway,
+		},
+		{
+			desc: "connection lost",
+			err:  &sftp.StatusError{Code: sftpConnectionLost},
+		},
+		{
+			desc: "canceled",
+			err:  context.Canceled,
+		},
+	}
+
+	rt := newTestRoundTripper(t)
+	r := newRequest(t, http.MethodGet, Protocol+"://localhost/file")
+
+	for i, tt := range tests {
+		res, err := rt.errorResponse(r, tt.err)
+		if tt.code == 0 {
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("[%02d] test %q, unexpected error: %v != %v",
+					i, tt.desc, tt.err, err)
+			}
+
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[%02d] test %q, unexpected error: %v", i, tt.desc, err)
+		}
+
+		if want, got := tt.code, res.StatusCode; want != got {
+			t.Fatalf("[%02d] test %q, unexpected status code: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func BenchmarkRoundTripperBufferSize(b *testing.B) {
+	const size = 8 * 1024 * 1024
+
+	s := newTestServer(b)
+	s.writeFile(b, "file", testFile(size))
+
+	for _, n := range []int{32 * 1024, 1024 * 1024} {
+		b.Run(fmt.Sprintf("%dKiB", n/1024), func(b *testing.B) {
+			rt := newTestRoundTripper(b, WithBufferSize(n))
+			c := &http.Client{Transport: rt}
+
+			b.SetBytes(size)
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				res, err := c.Get(s.url("file"))
+				if err != nil {
+					b.Fatalf("failed to perform request: %v", err)
+				}
+				if _, err := io.Copy(io.Discard, res.Body); err != nil {
+					b.Fatalf("failed to read body: %v", err)
+				}
+				_ = res.Body.Close()
+			}
+		})
+	}
+}
+
+// slowConn is a net.Conn whose reads are delayed while slow is set.
+type slowConn struct {
+	net.Conn
+	slow  *atomic.Bool
+	delay time.Duration
+}
+
+func (c *slowConn) Read(b []byte) (int, error) {
+	if c.slow.Load() {
+		time.Sleep(c.delay)
+	}
+
+	return c.Conn.Read(b)
+}
+
+func TestRoundTripperRequestTimeout(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "file", []byte("hello"))
+
+	var slow atomic.Bool
+	rt := newTestRoundTripper(t,
+		WithRequestTimeout(10*time.Millisecond),
+		WithDialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var d net.Dialer
+			c, err := d.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+
+			return &slowConn{
+				Conn:  c,
+				slow:  &slow,
+				delay: 25 * time.Millisecond,
+			}, nil
+		}),
+	)
+
+	// The host responds too slowly for the connection to be established
+	// and the file to be retrieved before the timeout
+	slow.Store(true)
+	res, _ := do(t, rt, newRequest(t, http.MethodGet, s.url("file")))
+	if want, got := http.StatusGatewayTimeout, res.StatusCode; want != got {
+		t.Fatalf("unexpected status code: %v != %v", want, got)
+	}
+
+	// Requests which carry their own deadline are not subject to the
+	// default timeout
+	slow.Store(false)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, body := do(t, rt, newRequest(t, http.MethodGet, s.url("file")).WithContext(ctx))
+	if want, got := http.StatusOK, res.StatusCode; want != got {
+		t.Fatalf("unexpected status code: %v != %v", want, got)
+	}
+	if want, got := "hello", string(body); want != got {
+		t.Fatalf("unexpected body: %q != %q", want, got)
+	}
+}
+
+func TestRoundTripperDialer(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "file", []byte("hello"))
+
+	var (
+		mu    sync.Mutex
+		addrs []string
+	)
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		addrs = append(addrs, network+" "+addr)
+
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+
+	rt := newTestRoundTripper(t, WithDialer(dial))
+	for i := 0; i < 2; i++ {
+		res, body := do(t, rt, newRequest(t, http.MethodGet, s.url("file")))
+		if want, got := http.StatusOK, res.StatusCode; want != got {
+			t.Fatalf("unexpected status code: %v != %v", want, got)
+		}
+		if want, got := "hello", string(body); want != got {
+			t.Fatalf("unexpected body: %q != %q", want, got)
+		}
+	}
+
+	// The connection is established once using the dialer, and reused
+	mu.Lock()
+	defer mu.Unlock()
+	if want, got := fmt.Sprint([]string{"tcp " + s.addr}), fmt.Sprint(addrs); want != got {
+		t.Fatalf("unexpected dials: %v != %v", want, got)
+	}
+	if want, got := int32(1), s.dials.Load(); want != got {
+		t.Fatalf("unexpected number of connections: %v != %v", want, got)
+	}
+
+	// Errors from the dialer are returned to the caller
+	errDial := errors.New("dial failed")
+	rt = newTestRoundTripper(t, WithDialer(func(context.Context, string, string) (net.Conn, error) {
+		return nil, errDial
+	}))
+	if _, err := rt.RoundTrip(newRequest(t, http.MethodGet, s.url("file"))); !errors.Is(err, errDial) {
+		t.Fatalf("unexpected error: %v != %v", errDial, err)
+	}
+}
+
+func TestRoundTripperWarm(t *testing.T) {
+	s1, s2 := newTestServer(t), newTestServer(t)
+	s1.writeFile(t, "file", []byte("hello"))
+
+	rt := newTestRoundTripper(t)
+	if err := rt.Warm(s1.addr, s2.addr); err != nil {
+		t.Fatalf("failed to warm connections: %v", err)
+	}
+
+	for _, s := range []*testServer{s1, s2} {
+		if _, ok := rt.SSHClient(s.addr); !ok {
+			t.Fatalf("no connection to %q after Warm", s.addr)
+		}
+		if want, got := int32(1), s.dials.Load(); want != got {
+			t.Fatalf("unexpected number of dials to %q: %v != %v", s.addr, want, got)
+		}
+	}
+
+	// Requests and further calls to Warm use the existing connections
+	if res, _ := do(t, rt, newRequest(t, http.MethodGet, s1.url("file"))); res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: %v", res.StatusCode)
+	}
+	if err := rt.Warm(s1.addr); err != nil {
+		t.Fatalf("failed to warm connections: %v", err)
+	}
+	if want, got := int32(1), s1.dials.Load(); want != got {
+		t.Fatalf("unexpected number of dials: %v != %v", want, got)
+	}
+
+	// Hosts which cannot be dialed are reported, without affecting the
+	// others
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	down := l.Addr().String()
+	_ = l.Close()
+
+	rt = newTestRoundTripper(t)
+	if err := rt.Warm(down, s2.addr); err == nil {
+		t.Fatal("expected an error for a host which cannot be dialed")
+	}
+	if _, ok := rt.SSHClient(down); ok {
+		t.Fatalf("unexpected connection to %q", down)
+	}
+	if _, ok := rt.SSHClient(s2.addr); !ok {
+		t.Fatalf("no connection to %q after Warm", s2.addr)
+	}
+}
+
+func TestRoundTripperDialPatterns(t *testing.T) {
+	s1, s2, s3 := newTestServer(t), newTestServer(t), newTestServer(t)
+	for _, s := range []*testServer{s1, s2, s3} {
+		s.writeFile(t, "file", []byte("hello"))
+	}
+
+	// Record which configuration was used to connect to each host
+	var (
+		mu   sync.Mutex
+		used = make(map[string]string)
+	)
+	config := func(name string) *ssh.ClientConfig {
+		c := testClientConfig()
+		c.HostKeyCallback = func(host string, _ net.Addr, _ ssh.PublicKey) error {
+			mu.Lock()
+			defer mu.Unlock()
+			used[host] = name
+			return nil
+		}
+
+		return c
+	}
+
+	rt := NewRoundTripper(config("default"))
+	defer rt.Close()
+
+	var tests = []struct {
+		pattern string
+		name    string
+	}{
+		{pattern: "*.example.com:22", name: "unmatched"},
+		{pattern: "127.0.0.1:*", name: "first"},
+		{pattern: "127.0.0.*:*", name: "second"},
+	}
+	for _, tt := range tests {
+		if err := rt.Dial(tt.pattern, config(tt.name)); err != nil {
+			t.Fatalf("failed to register pattern %q: %v", tt.pattern, err)
+		}
+	}
+	if err := rt.Dial("*[", nil); !errors.Is(err, path.ErrBadPattern) {
+		t.Fatalf("unexpected error for bad pattern: %v", err)
+	}
+
+	// Exact hosts take precedence over patterns, even when dialed again
+	if err := rt.Dial(s3.addr, config("exact")); err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	s3.closeConns()
+
+	for _, s := range []*testServer{s1, s2, s3} {
+		// The lost connection to s3 may fail one request
+		res, err := rt.RoundTrip(newRequest(t, http.MethodGet, s.url("file")))
+		if err != nil {
+			res, err = rt.RoundTrip(newRequest(t, http.MethodGet, s.url("file")))
+		}
+		if err != nil {
+			t.Fatalf("failed to perform request: %v", err)
+		}
+		_ = res.Body.Close()
+	}
+	if want, got := int32(2), s3.dials.Load(); want != got {
+		t.Fatalf("unexpected number of dials: %v != %v", want, got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	want := map[string]string{
+		s1.addr: "first",
+		s2.addr: "first",
+		s3.addr: "exact",
+	}
+	if !reflect.DeepEqual(want, used) {
+		t.Fatalf("unexpected configurations:\n- want: %v\n-  got: %v", want, used)
+	}
+}
+
+func TestRoundTripperHosts(t *testing.T) {
+	s1, s2 := newTestServer(t), newTestServer(t)
+
+	rt := newTestRoundTripper(t)
+	if got := rt.Hosts(); len(got) != 0 {
+		t.Fatalf("unexpected hosts before dialing: %v", got)
+	}
+
+	for _, s := range []*testServer{s2, s1} {
+		if err := rt.Dial(s.addr, nil); err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+	}
+
+	want := []string{s1.addr, s2.addr}
+	sort.Strings(want)
+
+	got := rt.Hosts()
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected hosts: %v != %v", want, got)
+	}
+
+	// The returned slice is a copy
+	got[0] = "modified"
+	if got := rt.Hosts(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected hosts after modification: %v != %v", want, got)
+	}
+
+	if err := rt.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if got := rt.Hosts(); len(got) != 0 {
+		t.Fatalf("unexpected hosts after close: %v", got)
+	}
+}
+
+func TestRoundTripperGetNotModified(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "file", []byte("hello"))
+
+	modTime := time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(filepath.FromSlash(s.path("file")), modTime, modTime); err != nil {
+		t.Fatalf("failed to set modification time: %v", err)
+	}
+
+	var tests = []struct {
+		desc  string
+		since time.Time
+		code  int
+		body  string
+	}{
+		{
+			desc:  "not modified",
+			since: modTime,
+			code:  http.StatusNotModified,
+		},
+		{
+			desc:  "not modified, later",
+			since: modTime.Add(time.Hour),
+			code:  http.StatusNotModified,
+		},
+		{
+			desc:  "modified",
+			since: modTime.Add(-time.Second),
+			code:  http.StatusOK,
+			body:  "hello",
+		},
+	}
+
+	rt := newTestRoundTripper(t)
+	for i, tt := range tests {
+		r := newRequest(t, http.MethodGet, s.url("file"))
+		r.Header.Set("If-Modified-Since", tt.since.Format(http.TimeFormat))
+
+		res, err := (&http.Client{Transport: rt}).Do(r)
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to perform request: %v", i, tt.desc, err)
+		}
+
+		// No transfer is started for a 304, so nothing is in flight even
+		// before the body is closed
+		if tt.code == http.StatusNotModified {
+			if want, got := int64(0), rt.Stats().InFlight[s.addr]; want != got {
+				t.Fatalf("[%02d] test %q, unexpected transfers in flight: %v != %v",
+					i, tt.desc, want, got)
+			}
+			if want, got := modTime.Format(http.TimeFormat), res.Header.Get("Last-Modified"); want != got {
+				t.Fatalf("[%02d] test %q, unexpected Last-Modified: %q != %q",
+					i, tt.desc, want, got)
+			}
+		}
+
+		b, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to read body: %v", i, tt.desc, err)
+		}
+
+		if want, got := tt.code, res.StatusCode; want != got {
+			t.Fatalf("[%02d] test %q, unexpected status code: %v != %v",
+				i, tt.desc, want, got)
+		}
+		if want, got := tt.body, string(b); want != got {
+			t.Fatalf("[%02d] test %q, unexpected body: %q != %q",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestRoundTripperResponseRequest(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "file", []byte("hello"))
+
+	var tests = []struct {
+		desc   string
+		opts   []Option
+		method string
+		name   string
+		code   int
+	}{
+		{
+			desc:   "OK",
+			method: http.MethodGet,
+			name:   "file",
+			code:   http.StatusOK,
+		},
+		{
+			desc:   "not found",
+			method: http.MethodGet,
+			name:   "missing",
+			code:   http.StatusNotFound,
+		},
+		{
+			desc:   "method not allowed",
+			method: http.MethodDelete,
+			name:   "file",
+			code:   http.StatusMethodNotAllowed,
+		},
+		{
+			desc: "rewritten by hook",
+			opts: []Option{WithRequestHook(func(r *http.Request) (*http.Request, error) {
+				r = r.Clone(r.Context())
+				r.URL.Path = s.path("file")
+				return r, nil
+			})},
+			method: http.MethodGet,
+			name:   "missing",
+			code:   http.StatusOK,
+		},
+		{
+			desc: "short circuit",
+			opts: []Option{WithRequestHook(func(*http.Request) (*http.Request, error) {
+				return nil, &ShortCircuit{Response: &http.Response{
+					StatusCode: http.StatusTeapot,
+					Body:       http.NoBody,
+				}}
+			})},
+			method: http.MethodGet,
+			name:   "file",
+			code:   http.StatusTeapot,
+		},
+	}
+
+	for i, tt := range tests {
+		rt := newTestRoundTripper(t, tt.opts...)
+
+		r := newRequest(t, tt.method, s.url(tt.name))
+		res, err := rt.RoundTrip(r)
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to perform request: %v", i, tt.desc, err)
+		}
+		_ = res.Body.Close()
+
+		if want, got := tt.code, res.StatusCode; want != got {
+			t.Fatalf("[%02d] test %q, unexpected status code: %v != %v",
+				i, tt.desc, want, got)
+		}
+
+		// The response always refers to the caller's original request
+		if res.Request != r {
+			t.Fatalf("[%02d] test %q, unexpected request: %v != %v",
+				i, tt.desc, r, res.Request)
+		}
+		if tt.code == http.StatusTeapot {
+			continue
+		}
+
+		if want, got := "HTTP/1.1", res.Proto; want != got {
+			t.Fatalf("[%02d] test %q, unexpected protocol: %q != %q",
+				i, tt.desc, want, got)
+		}
+		if res.ProtoMajor != 1 || res.ProtoMinor != 1 {
+			t.Fatalf("[%02d] test %q, unexpected protocol version: %d.%d",
+				i, tt.desc, res.ProtoMajor, res.ProtoMinor)
+		}
+	}
+}
+
+func TestRoundTripperServerExtensions(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "file", []byte("hello"))
+
+	rt := newTestRoundTripper(t)
+
+	exts, err := rt.ServerExtensions(s.addr)
+	if err != nil {
+		t.Fatalf("failed to retrieve extensions: %v", err)
+	}
+
+	// The test server advertises the default extensions of pkg/sftp
+	want := map[string]string{
+		"hardlink@openssh.com":     "1",
+		"posix-rename@openssh.com": "1",
+		"statvfs@openssh.com":      "2",
+	}
+	if got := exts; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected extensions:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// The connection used to retrieve extensions is reused for requests
+	res, _ := do(t, rt, newRequest(t, http.MethodGet, s.url("file")))
+	if want, got := http.StatusOK, res.StatusCode; want != got {
+		t.Fatalf("unexpected status code: %v != %v", want, got)
+	}
+	if want, got := int32(1), s.dials.Load(); want != got {
+		t.Fatalf("unexpected number of dials: %v != %v", want, got)
+	}
+
+	// Hosts which cannot be reached are errors
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	down := l.Addr().String()
+	_ = l.Close()
+
+	if _, err := rt.ServerExtensions(down); err == nil {
+		t.Fatal("expected an error for an unreachable host")
+	}
+}
@@ -3,6 +3,16 @@
 package sshttp
 
 import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 )
@@ -13,51 +23,239 @@ const (
 	Protocol = "sftp"
 )
 
+// ErrClosed is returned by RoundTripper when a request is made after
+// Shutdown is called.
+var ErrClosed = errors.New("sshttp: RoundTripper is shut down")
+
+// ErrFileTooLarge is returned by FileSystem when an attempt is made to open
+// a file which exceeds the size configured using WithMaxFileSize.
+var ErrFileTooLarge = errors.New("sshttp: file exceeds maximum size")
+
 // clientPair stores a pair of SSH and SFTP client structs which are connected
 // to a single host.
 type clientPair struct {
 	sshc  *ssh.Client
 	sftpc *sftp.Client
+
+	// Number of requests currently being served using this pair, and the
+	// time at which it was last used, in nanoseconds since the Unix epoch
+	inFlight atomic.Int64
+	lastUsed atomic.Int64
+
+	// Caches of directory contents, open files, and missing paths, if
+	// enabled
+	dirs    *dirCache
+	handles *handleCache
+	missing *negativeCache
+
+	// Closed when p is closed, to stop background goroutines
+	done     chan struct{}
+	doneOnce sync.Once
+
+	// Set when the connection is detected to be broken
+	broken atomic.Bool
+}
+
+// close closes any cached files, and the SFTP and SSH clients for p.
+func (p *clientPair) close() error {
+	p.doneOnce.Do(func() {
+		close(p.done)
+	})
+
+	if p.handles != nil {
+		p.handles.close()
+	}
+
+	var sErr stickyError
+	sErr.Set(p.sftpc.Close())
+	sErr.Set(p.sshc.Close())
+
+	return sErr.Get()
 }
 
 // dialSSHSFTP dials a SSH connection to the specified host using the specified
 // configuration, and then creates a SFTP client using the underlying SSH
 // connection.  Both are returned in a clientPair struct, which is used by various
-// types in this package.
-func dialSSHSFTP(host string, config *ssh.ClientConfig) (*clientPair, error) {
+// types in this package.  The network connection is established using the
+// dialer from o, if one is set.
+func dialSSHSFTP(host string, config *ssh.ClientConfig, o *options) (*clientPair, error) {
+	// Establish the network connection, honoring the configured timeout
+	dial := o.dialer
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: config.Timeout}).DialContext
+	}
+
+	ctx := context.Background()
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	conn, err := dial(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+
+	return newClientPair(conn, host, config, o)
+}
+
+// newClientPair establishes a SSH connection to host over the existing
+// network connection conn, and then creates a SFTP client using the SSH
+// connection.  conn is closed if either cannot be established.
+func newClientPair(conn net.Conn, host string, config *ssh.ClientConfig, o *options) (*clientPair, error) {
+	// Apply host key algorithms to a copy of the configuration, so the
+	// caller's configuration is not modified
+	if len(o.hostKeyAlgorithms) > 0 {
+		c := *config
+		c.HostKeyAlgorithms = o.hostKeyAlgorithms
+		config = &c
+	}
+
 	// Open initial SSH connection
-	sshc, err := ssh.Dial("tcp", host, config)
+	c, chans, reqs, err := ssh.NewClientConn(conn, host, config)
 	if err != nil {
+		_ = conn.Close()
 		return nil, err
 	}
+	sshc := ssh.NewClient(c, chans, reqs)
 
 	// Open SFTP subsystem using SSH connection
-	sftpc, err := sftp.NewClient(sshc)
+	sftpc, err := newSFTPClient(sshc, o.subsystem, o.sftpOptions)
+	if err != nil {
+		_ = sshc.Close()
+		return nil, err
+	}
+
+	p := &clientPair{
+		sshc:    sshc,
+		sftpc:   sftpc,
+		dirs:    newDirCache(o.dirCacheTTL),
+		handles: newHandleCache(o.handleCacheSize),
+		missing: newNegativeCache(o.negativeCacheTTL),
+		done:    make(chan struct{}),
+	}
+	p.lastUsed.Store(time.Now().UnixNano())
+
+	// Mark the connection broken as soon as the SFTP session ends, such as
+	// when the remote host closes the connection, so it is not used again
+	go func() {
+		_ = sftpc.Wait()
+		p.broken.Store(true)
+	}()
+
+	if o.keepAlive > 0 {
+		go p.keepAlive(o.keepAlive)
+	}
+
+	return p, nil
+}
+
+// newSFTPClient creates a SFTP client using the SSH connection sshc and the
+// client options opts.  If subsystem is set, it is requested instead of the
+// default SFTP subsystem.
+func newSFTPClient(sshc *ssh.Client, subsystem string, opts []sftp.ClientOption) (*sftp.Client, error) {
+	if subsystem == "" {
+		return sftp.NewClient(sshc, opts...)
+	}
+
+	s, err := sshc.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	pw, err := s.StdinPipe()
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	pr, err := s.StdoutPipe()
 	if err != nil {
+		_ = s.Close()
 		return nil, err
 	}
 
-	return &clientPair{
-		sshc:  sshc,
-		sftpc: sftpc,
-	}, nil
+	if err := s.RequestSubsystem(subsystem); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+
+	sftpc, err := sftp.NewClientPipe(pr, pw, opts...)
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+
+	return sftpc, nil
+}
+
+// fsError translates SFTP status errors into their standard library
+// equivalents, so that callers may use checks such as os.IsNotExist.  Any
+// other errors are returned unmodified.
+func fsError(err error) error {
+	var serr *sftp.StatusError
+	if !errors.As(err, &serr) {
+		return err
+	}
+
+	switch serr.Code {
+	case sftpNoSuchFile:
+		return os.ErrNotExist
+	case sftpPermissionDenied:
+		return os.ErrPermission
+	}
+
+	return err
+}
+
+// hasDotfile reports whether any element of the cleaned path p begins
+// with a dot.
+func hasDotfile(p string) bool {
+	for _, e := range strings.Split(path.Clean("/"+p), "/") {
+		if strings.HasPrefix(e, ".") {
+			return true
+		}
+	}
+
+	return false
 }
 
 // stickyError is an error which traps the first error sent to Set, and
-// returns it once Get is called.  It will ignore any subsequent errors.
+// returns it once Get is called.  It will ignore any subsequent errors,
+// unless join is set, in which case subsequent errors are joined onto the
+// first using errors.Join.
 type stickyError struct {
-	err error
+	err  error
+	join bool
 }
 
 // Error implements the error interface for stickyError.
 func (e *stickyError) Error() string {
+	if e.err == nil {
+		return "<nil>"
+	}
+
 	return e.err.Error()
 }
 
+// Unwrap returns the error stored by stickyError, so that errors.Is and
+// errors.As can inspect it.
+func (e *stickyError) Unwrap() error {
+	return e.err
+}
+
 // Set accepts an input error.  If an error is already occurred, it is
-// ignored.  If one has not yet occurred, it is stored.
+// ignored, or joined with the existing error if join is set.  If one has
+// not yet occurred, it is stored.
 func (e *stickyError) Set(err error) {
+	if err == nil {
+		return
+	}
 	if e.err != nil {
+		if e.join {
+			e.err = errors.Join(e.err, err)
+		}
+
 		return
 	}
 
@@ -0,0 +1,525 @@
+package sshttp
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// testServer is a SSH server which serves a temporary local directory using
+// the SFTP subsystem, for use in tests.
+type testServer struct {
+	// Address of the server, and the local directory it serves
+	addr string
+	root string
+
+	// Number of SSH connections accepted by the server
+	dials atomic.Int32
+
+	config *ssh.ServerConfig
+	l      net.Listener
+
+	// Accepted connections, and the name of the SFTP subsystem if not
+	// the default
+	mu        sync.Mutex
+	conns     []net.Conn
+	subsystem string
+}
+
+// newTestServer starts a testServer which is shut down when the test ends.
+func newTestServer(t testing.TB) *testServer {
+	t.Helper()
+	return listenTestServer(t, "127.0.0.1:0")
+}
+
+// listenTestServer starts a testServer listening on addr, which is shut down
+// when the test ends.
+func listenTestServer(t testing.TB, addr string) *testServer {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate host key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("failed to create host key signer: %v", err)
+	}
+
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	s := &testServer{
+		addr:   l.Addr().String(),
+		root:   filepath.ToSlash(t.TempDir()),
+		config: config,
+		l:      l,
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.conns = append(s.conns, c)
+			s.mu.Unlock()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.serve(c)
+			}()
+		}
+	}()
+
+	t.Cleanup(func() {
+		s.stop()
+		wg.Wait()
+	})
+
+	return s
+}
+
+// stop stops accepting connections and closes each existing connection.
+func (s *testServer) stop() {
+	_ = s.l.Close()
+	s.closeConns()
+}
+
+// serve serves SFTP sessions over the SSH connection c.
+func (s *testServer) serve(c net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(c, s.config)
+	if err != nil {
+		return
+	}
+	s.dials.Add(1)
+	go ssh.DiscardRequests(reqs)
+
+	s.mu.Lock()
+	subsystem := s.subsystem
+	s.mu.Unlock()
+	if subsystem == "" {
+		subsystem = "sftp"
+	}
+
+	for nc := range chans {
+		switch nc.ChannelType() {
+		case "session":
+		case "direct-tcpip":
+			go s.forward(nc)
+			continue
+		default:
+			_ = nc.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+
+		ch, creqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+
+		go func() {
+			for req := range creqs {
+				ok := req.Type == "subsystem" && subsystemName(req.Payload) == subsystem
+				_ = req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+
+				go func() {
+					defer ch.Close()
+
+					srv, err := sftp.NewServer(ch)
+					if err != nil {
+						return
+					}
+					_ = srv.Serve()
+				}()
+			}
+		}()
+	}
+}
+
+// forward serves a direct-tcpip channel by connecting to the requested
+// address from the server.
+func (s *testServer) forward(nc ssh.NewChannel) {
+	var req struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(nc.ExtraData(), &req); err != nil {
+		_ = nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	c, err := net.Dial("tcp", net.JoinHostPort(req.Host, strconv.Itoa(int(req.Port))))
+	if err != nil {
+		_ = nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer c.Close()
+
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = io.Copy(c, ch)
+		_ = c.(*net.TCPConn).CloseWrite()
+	}()
+	_, _ = io.Copy(ch, c)
+	_ = ch.CloseWrite()
+	<-done
+}
+
+// closeConns closes each connection accepted by the server, as if the server
+// had been restarted.
+func (s *testServer) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.conns {
+		_ = c.Close()
+	}
+}
+
+// subsystemName parses the subsystem name from the payload of a SSH
+// subsystem request.
+func subsystemName(b []byte) string {
+	if len(b) < 4 || int(binary.BigEndian.Uint32(b)) != len(b)-4 {
+		return ""
+	}
+
+	return string(b[4:])
+}
+
+// path returns the remote path of name under the served directory.
+func (s *testServer) path(name string) string {
+	return path.Join(s.root, name)
+}
+
+// url returns the sftp URL of name under the served directory.
+func (s *testServer) url(name string) string {
+	return Protocol + "://" + s.addr + s.path(name)
+}
+
+// writeFile creates the file name under the served directory, along with
+// any parent directories.
+func (s *testServer) writeFile(t testing.TB, name string, b []byte) {
+	t.Helper()
+
+	p := filepath.FromSlash(s.path(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(p, b, 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+}
+
+// client dials the server and returns a SFTP client which is closed when the
+// test ends.
+func (s *testServer) client(t testing.TB) *sftp.Client {
+	t.Helper()
+
+	sshc, err := ssh.Dial("tcp", s.addr, testClientConfig())
+	if err != nil {
+		t.Fatalf("failed to dial SSH: %v", err)
+	}
+	sftpc, err := sftp.NewClient(sshc)
+	if err != nil {
+		_ = sshc.Close()
+		t.Fatalf("failed to create SFTP client: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = sftpc.Close()
+		_ = sshc.Close()
+	})
+
+	return sftpc
+}
+
+// testClientConfig returns a SSH client configuration for a testServer.
+func testClientConfig() *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:            "sshttp",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+}
+
+// newTestRoundTripper creates a RoundTripper using opts which is closed when
+// the test ends.
+func newTestRoundTripper(t testing.TB, opts ...Option) *RoundTripper {
+	t.Helper()
+
+	rt := NewRoundTripper(testClientConfig(), opts...)
+	t.Cleanup(func() {
+		_ = rt.Close()
+	})
+
+	return rt
+}
+
+func TestFSError(t *testing.T) {
+	other := errors.New("other")
+
+	var tests = []struct {
+		desc string
+		err  error
+		want error
+	}{
+		{
+			desc: "nil",
+		},
+		{
+			desc: "no such file",
+			err:  &sftp.StatusError{Code: sftpNoSuchFile},
+			want: os.ErrNotExist,
+		},
+		{
+			desc: "permission denied",
+			err:  &sftp.StatusError{Code: sftpPermissionDenied},
+			want: os.ErrPermission,
+		},
+		{
+			desc: "other status",
+			err:  &sftp.StatusError{Code: sftpConnectionLost},
+		},
+		{
+			desc: "other error",
+			err:  other,
+			want: other,
+		},
+	}
+
+	for i, tt := range tests {
+		want := tt.want
+		if want == nil {
+			want = tt.err
+		}
+
+		if got := fsError(tt.err); got != want {
+			t.Fatalf("[%02d] test %q, unexpected error: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestHasDotfile(t *testing.T) {
+	var tests = []struct {
+		path string
+		ok   bool
+	}{
+		{path: "/", ok: false},
+		{path: "/foo/bar", ok: false},
+		{path: "/foo.d/bar.txt", ok: false},
+		{path: "/.ssh", ok: true},
+		{path: "/foo/.git/config", ok: true},
+		{path: "foo/.bar", ok: true},
+		{path: "/foo/../.bar", ok: true},
+		{path: "/foo/./bar", ok: false},
+		{path: "/foo/..", ok: false},
+	}
+
+	for i, tt := range tests {
+		if want, got := tt.ok, hasDotfile(tt.path); want != got {
+			t.Fatalf("[%02d] path %q, unexpected result: %v != %v",
+				i, tt.path, want, got)
+		}
+	}
+}
+
+func TestStickyError(t *testing.T) {
+	errFoo := errors.New("foo")
+	errBar := errors.New("bar")
+
+	var tests = []struct {
+		desc string
+		join bool
+		errs []error
+		want string
+		is   []error
+		not  []error
+	}{
+		{
+			desc: "no errors",
+			want: "<nil>",
+			not:  []error{errFoo},
+		},
+		{
+			desc: "nil errors",
+			errs: []error{nil, nil},
+			want: "<nil>",
+		},
+		{
+			desc: "first error",
+			errs: []error{nil, errFoo, errBar},
+			want: "foo",
+			is:   []error{errFoo},
+			not:  []error{errBar},
+		},
+		{
+			desc: "joined errors",
+			join: true,
+			errs: []error{errFoo, nil, errBar},
+			want: "foo\nbar",
+			is:   []error{errFoo, errBar},
+		},
+	}
+
+	for i, tt := range tests {
+		sErr := &stickyError{join: tt.join}
+		for _, err := range tt.errs {
+			sErr.Set(err)
+		}
+
+		if want, got := tt.want, sErr.Error(); want != got {
+			t.Fatalf("[%02d] test %q, unexpected error string: %q != %q",
+				i, tt.desc, want, got)
+		}
+
+		for _, err := range tt.is {
+			if !errors.Is(sErr, err) {
+				t.Fatalf("[%02d] test %q, error does not match %v",
+					i, tt.desc, err)
+			}
+		}
+		for _, err := range tt.not {
+			if errors.Is(sErr, err) {
+				t.Fatalf("[%02d] test %q, error unexpectedly matches %v",
+					i, tt.desc, err)
+			}
+		}
+	}
+}
+
+func TestStickyErrorAs(t *testing.T) {
+	var sErr stickyError
+	sErr.Set(&os.PathError{Op: "open", Path: "/foo", Err: os.ErrNotExist})
+	sErr.Set(errors.New("ignored"))
+
+	var perr *os.PathError
+	if !errors.As(&sErr, &perr) {
+		t.Fatal("stickyError does not unwrap to *os.PathError")
+	}
+	if want, got := "/foo", perr.Path; want != got {
+		t.Fatalf("unexpected path: %q != %q", want, got)
+	}
+	if !errors.Is(&sErr, os.ErrNotExist) {
+		t.Fatal("stickyError does not match os.ErrNotExist")
+	}
+}
+
+func TestSubsystem(t *testing.T) {
+	s := newTestServer(t)
+	s.mu.Lock()
+	s.subsystem = "custom-sftp"
+	s.mu.Unlock()
+	s.writeFile(t, "file", []byte("hello"))
+
+	// The default subsystem is refused by this server
+	rt := newTestRoundTripper(t)
+	if _, err := rt.RoundTrip(newRequest(t, http.MethodGet, s.url("file"))); err == nil {
+		t.Fatal("expected an error for the default subsystem")
+	}
+
+	rt = newTestRoundTripper(t, WithSubsystem("custom-sftp"))
+	res, body := do(t, rt, newRequest(t, http.MethodGet, s.url("file")))
+	if want, got := http.StatusOK, res.StatusCode; want != got {
+		t.Fatalf("unexpected status code: %v != %v", want, got)
+	}
+	if want, got := "hello", string(body); want != got {
+		t.Fatalf("unexpected body: %q != %q", want, got)
+	}
+
+	// FileSystem uses the same option
+	fs := newTestFileSystem(t, s, s.root, WithSubsystem("custom-sftp"))
+	if _, err := fs.Stat("file"); err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+}
+
+func TestHostKeyAlgorithms(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "file", []byte("hello"))
+
+	var tests = []struct {
+		desc  string
+		algos []string
+		ok    bool
+	}{
+		{
+			desc: "default",
+			ok:   true,
+		},
+		{
+			desc:  "supported",
+			algos: []string{ssh.KeyAlgoRSASHA256, ssh.KeyAlgoED25519},
+			ok:    true,
+		},
+		{
+			desc:  "unsupported",
+			algos: []string{ssh.KeyAlgoRSA},
+		},
+	}
+
+	for i, tt := range tests {
+		config := testClientConfig()
+		var opts []Option
+		if tt.algos != nil {
+			opts = append(opts, WithHostKeyAlgorithms(tt.algos...))
+		}
+
+		rt := NewRoundTripper(config, opts...)
+		res, err := rt.RoundTrip(newRequest(t, http.MethodGet, s.url("file")))
+		if err == nil {
+			_ = res.Body.Close()
+		}
+		_ = rt.Close()
+
+		// The server only offers an ed25519 host key, so handshakes fail
+		// unless that algorithm is accepted
+		if want, got := tt.ok, err == nil; want != got {
+			t.Fatalf("[%02d] test %q, unexpected error: %v", i, tt.desc, err)
+		}
+
+		// The caller's configuration is never modified
+		if config.HostKeyAlgorithms != nil {
+			t.Fatalf("[%02d] test %q, configuration was modified: %v",
+				i, tt.desc, config.HostKeyAlgorithms)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package sshttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// Stat retrieves information about the remote file identified by rawURL,
+// such as sftp://example.com:22/var/log/syslog, without opening the file or
+// constructing a HTTP request.  The URL's host is dialed or reused from the
+// connection pool in the same manner as RoundTrip, and the same path rules
+// apply.  Symbolic links are followed.  If the file does not exist, Stat
+// returns a *os.PathError which satisfies errors.Is(err, os.ErrNotExist).
+func (rt *RoundTripper) Stat(rawURL string) (os.FileInfo, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("sshttp: URL %q has no host", rawURL)
+	}
+
+	return rt.stat(u.Host, u.Path)
+}
+
+// Exists reports whether the file named name exists on the remote host, using
+// an existing connection to host if one is open.  Exists does not open the
+// file, so it is cheaper than a GET request.  If the file does not exist, or
+// may not be served due to rules such as WithDenyDotfiles, Exists returns
+// false and a nil error.  Any other error, such as os.ErrPermission, is
+// returned.
+func (rt *RoundTripper) Exists(host string, name string) (bool, error) {
+	_, err := rt.stat(host, name)
+	return exists(err)
+}
+
+// Exists reports whether the named file exists.  Like Stat, symbolic links
+// are followed.  If the file does not exist, Exists returns false and a nil
+// error.  Any other error, such as os.ErrPermission, is returned.
+func (fs *FileSystem) Exists(name string) (bool, error) {
+	_, err := fs.Stat(name)
+	return exists(err)
+}
+
+// stat retrieves information about the file named name on the remote host,
+// subject to the same path rules as RoundTrip.  Errors satisfy os.IsNotExist
+// and os.IsPermission where appropriate.
+func (rt *RoundTripper) stat(host string, name string) (os.FileInfo, error) {
+	name = rt.opts.cleanPath(name)
+	if err := rt.opts.checkPath(name); err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+
+	p, err := rt.pair(host)
+	if err != nil {
+		return nil, err
+	}
+
+	var fi os.FileInfo
+	err = rt.opts.retry(context.Background(), func() (err error) {
+		fi, err = p.sftpc.Stat(name)
+		return err
+	})
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: fsError(err)}
+	}
+
+	return fi, nil
+}
+
+// exists converts the result of a stat operation into the return values
+// of Exists.
+func exists(err error) (bool, error) {
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, os.ErrNotExist):
+		return false, nil
+	default:
+		return false, err
+	}
+}
@@ -0,0 +1,133 @@
+package sshttp
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+func TestRoundTripperExists(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "file", []byte("hello"))
+	s.writeFile(t, ".hidden", []byte("hello"))
+	s.writeFile(t, "secret", []byte("hello"))
+
+	rt := newTestRoundTripper(t,
+		WithDenyDotfiles(true),
+		WithDenyGlobs(s.path("secret")),
+	)
+
+	var tests = []struct {
+		desc   string
+		name   string
+		exists bool
+		perm   bool
+	}{
+		{
+			desc:   "existing file",
+			name:   "file",
+			exists: true,
+		},
+		{
+			desc: "missing file",
+			name: "missing",
+		},
+		{
+			desc: "dotfile",
+			name: ".hidden",
+		},
+		{
+			desc: "denied file",
+			name: "secret",
+			perm: true,
+		},
+	}
+
+	for i, tt := range tests {
+		ok, err := rt.Exists(s.addr, s.path(tt.name))
+		if want, got := tt.perm, os.IsPermission(err); want != got {
+			t.Fatalf("[%02d] test %q, unexpected permission error: %v != %v: %v",
+				i, tt.desc, want, got, err)
+		}
+		if !tt.perm && err != nil {
+			t.Fatalf("[%02d] test %q, unexpected error: %v", i, tt.desc, err)
+		}
+		if want, got := tt.exists, ok; want != got {
+			t.Fatalf("[%02d] test %q, unexpected existence: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+
+	// Hosts which cannot be reached are errors, rather than missing files
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	down := l.Addr().String()
+	_ = l.Close()
+
+	ok, err := rt.Exists(down, s.path("file"))
+	if err == nil || os.IsNotExist(err) {
+		t.Fatalf("unexpected error for an unreachable host: %v", err)
+	}
+	if ok {
+		t.Fatal("file on an unreachable host exists")
+	}
+}
+
+func TestFileSystemExists(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "root/file", []byte("hello"))
+	s.writeFile(t, "root/dir/file", []byte("hello"))
+	s.writeFile(t, "root/secret", []byte("hello"))
+
+	fs := newTestFileSystem(t, s, s.path("root"),
+		WithDenyGlobs("/secret"),
+	)
+
+	var tests = []struct {
+		desc   string
+		name   string
+		exists bool
+		perm   bool
+	}{
+		{
+			desc:   "existing file",
+			name:   "file",
+			exists: true,
+		},
+		{
+			desc:   "existing directory",
+			name:   "dir",
+			exists: true,
+		},
+		{
+			desc: "missing file",
+			name: "missing",
+		},
+		{
+			desc: "missing parent",
+			name: "missing/file",
+		},
+		{
+			desc: "denied file",
+			name: "secret",
+			perm: true,
+		},
+	}
+
+	for i, tt := range tests {
+		ok, err := fs.Exists(tt.name)
+		if want, got := tt.perm, os.IsPermission(err); want != got {
+			t.Fatalf("[%02d] test %q, unexpected permission error: %v != %v: %v",
+				i, tt.desc, want, got, err)
+		}
+		if !tt.perm && err != nil {
+			t.Fatalf("[%02d] test %q, unexpected error: %v", i, tt.desc, err)
+		}
+		if want, got := tt.exists, ok; want != got {
+			t.Fatalf("[%02d] test %q, unexpected existence: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package sshttp
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+)
+
+// ErrTooManyLinks is returned by FileSystem when resolving a path would
+// follow more symbolic links than permitted by WithFollowSymlinks, such as
+// when a symbolic link refers to itself.
+var ErrTooManyLinks = errors.New("sshttp: too many levels of symbolic links")
+
+// WithFollowSymlinks configures a FileSystem to resolve symbolic links
+// itself when opening or describing files, following at most hops links
+// for a single path.  Resolved targets must lie under the directory
+// specified in NewFileSystem; links which escape it are reported as
+// permission errors.  The target of each link must also be permitted by
+// options such as WithDenyDotfiles and WithDenyGlobs, as if it had been
+// requested directly.  Symbolic links in parent directories are resolved in
+// the same manner when creating, renaming, or removing files, but a link
+// which is itself renamed or removed is never followed.
+//
+// By default, symbolic links are resolved by the remote host without any
+// such restrictions.  Symbolic links are always reported as-is by Lstat,
+// Walk, and File.Readdir.
+func WithFollowSymlinks(hops int) Option {
+	return func(o *options) {
+		o.symlinkHops = hops
+	}
+}
+
+// target returns the remote path which should be accessed for name,
+// resolving symbolic links if configured to do so.
+//
+// Each element of name is resolved in turn, starting from the root
+// directory, so that symbolic links in parent directories are resolved and
+// checked as well.  This does not rely on the remote host to resolve them,
+// as not all SFTP servers do so when asked for a canonical path.
+func (fs *FileSystem) target(name string) (string, error) {
+	if fs.opts.symlinkHops <= 0 {
+		return fs.join(name), nil
+	}
+
+	fpath := fs.root
+	elems := strings.Split(fs.opts.cleanPath(name), "/")
+	for hops := 0; len(elems) > 0; {
+		elem := elems[0]
+		elems = elems[1:]
+
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			fpath = path.Dir(fpath)
+			continue
+		}
+
+		next := path.Join(fpath, elem)
+		fi, err := fs.pair.sftpc.Lstat(next)
+		if err != nil {
+			return "", fsError(err)
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			fpath = next
+			continue
+		}
+
+		hops++
+		if hops > fs.opts.symlinkHops {
+			return "", ErrTooManyLinks
+		}
+
+		link, err := fs.pair.sftpc.ReadLink(next)
+		if err != nil {
+			return "", fsError(err)
+		}
+		link = fs.opts.slashPath(link)
+		if path.IsAbs(link) {
+			fpath = "/"
+		}
+
+		// Each link's target is subject to the same rules as the
+		// requested name, so links cannot expose denied files
+		if err := fs.checkTarget(path.Join(fpath, link)); err != nil {
+			return "", err
+		}
+
+		// Resolve the elements of the link's target before any which
+		// remain in name
+		elems = append(strings.Split(link, "/"), elems...)
+	}
+
+	if !within(fs.root, fpath) {
+		return "", os.ErrPermission
+	}
+	if err := fs.checkTarget(fpath); err != nil {
+		return "", err
+	}
+
+	return fpath, nil
+}
+
+// checkTarget ensures that the remote path fpath, resolved from a symbolic
+// link, may be served in the same manner as checkPath does for requested
+// names.  Paths outside of the root directory are not checked, as they are
+// rejected once fully resolved.
+func (fs *FileSystem) checkTarget(fpath string) error {
+	for _, root := range []string{fs.path, fs.root} {
+		if root != "" && within(root, fpath) {
+			return fs.opts.checkPath(strings.TrimPrefix(fpath, root))
+		}
+	}
+
+	return nil
+}
+
+// entry returns the remote path of the directory entry for name, without
+// following a symbolic link at name itself, for use by operations which
+// create, rename, or remove entries.  If configured to resolve symbolic
+// links, those in parent directories are resolved and confined to the root
+// directory in the same manner as target, so that such operations cannot
+// escape it.
+func (fs *FileSystem) entry(name string) (string, error) {
+	name = fs.opts.cleanPath(name)
+	if fs.opts.symlinkHops <= 0 || name == "/" {
+		return fs.join(name), nil
+	}
+
+	dir, err := fs.target(path.Dir(name))
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(dir, path.Base(name)), nil
+}
+
+// within reports whether path is root or a descendant of root.
+func within(root string, path string) bool {
+	if root == "/" || path == root {
+		return true
+	}
+
+	return strings.HasPrefix(path, root+"/")
+}
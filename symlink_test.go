@@ -0,0 +1,192 @@
+package sshttp
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSystemFollowSymlinks(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "root/dir/file", []byte("hello"))
+	s.writeFile(t, "outside", []byte("secret"))
+
+	for _, l := range []struct {
+		target string
+		name   string
+	}{
+		{target: "dir/file", name: "root/relative"},
+		{target: s.path("root/dir"), name: "root/absolute"},
+		{target: "relative", name: "root/chain"},
+		{target: "../outside", name: "root/escape"},
+		{target: s.path("outside"), name: "root/escape-absolute"},
+		{target: "..", name: "root/parent"},
+		{target: "loop", name: "root/loop"},
+		{target: "b", name: "root/a"},
+		{target: "a", name: "root/b"},
+	} {
+		if err := os.Symlink(filepath.FromSlash(l.target), filepath.FromSlash(s.path(l.name))); err != nil {
+			t.Fatalf("failed to create symbolic link: %v", err)
+		}
+	}
+
+	fs := newTestFileSystem(t, s, s.path("root"), WithFollowSymlinks(4))
+
+	var tests = []struct {
+		desc string
+		name string
+		err  error
+	}{
+		{
+			desc: "relative link",
+			name: "relative",
+		},
+		{
+			desc: "absolute link to directory",
+			name: "absolute/file",
+		},
+		{
+			desc: "chained links",
+			name: "chain",
+		},
+		{
+			desc: "escaping relative link",
+			name: "escape",
+			err:  os.ErrPermission,
+		},
+		{
+			desc: "escaping absolute link",
+			name: "escape-absolute",
+			err:  os.ErrPermission,
+		},
+		{
+			desc: "escaping parent directory link",
+			name: "parent/outside",
+			err:  os.ErrPermission,
+		},
+		{
+			desc: "self-referential link",
+			name: "loop",
+			err:  ErrTooManyLinks,
+		},
+		{
+			desc: "link cycle",
+			name: "a",
+			err:  ErrTooManyLinks,
+		},
+	}
+
+	for i, tt := range tests {
+		b, err := fs.ReadFile(tt.name)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("[%02d] test %q, unexpected error: %v != %v",
+					i, tt.desc, tt.err, err)
+			}
+
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to read file: %v",
+				i, tt.desc, err)
+		}
+		if want, got := "hello", string(b); want != got {
+			t.Fatalf("[%02d] test %q, unexpected contents: %q != %q",
+				i, tt.desc, want, got)
+		}
+	}
+
+	// Links are reported as-is without being followed
+	fi, err := fs.Lstat("escape")
+	if err != nil {
+		t.Fatalf("failed to lstat link: %v", err)
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("Lstat did not report a symbolic link: %v", fi.Mode())
+	}
+}
+
+func TestFileSystemFollowSymlinksCheckTargets(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "root/dir/file", []byte("hello"))
+	s.writeFile(t, "root/.secret", []byte("secret"))
+	s.writeFile(t, "root/private/key", []byte("secret"))
+
+	for _, l := range []struct {
+		target string
+		name   string
+	}{
+		{target: "dir/file", name: "root/allowed"},
+		{target: ".secret", name: "root/dotfile"},
+		{target: "dotfile", name: "root/chain"},
+		{target: s.path("root/private/key"), name: "root/denied"},
+		{target: "../private", name: "root/dir/private"},
+	} {
+		if err := os.Symlink(filepath.FromSlash(l.target), filepath.FromSlash(s.path(l.name))); err != nil {
+			t.Fatalf("failed to create symbolic link: %v", err)
+		}
+	}
+
+	fs := newTestFileSystem(t, s, s.path("root"),
+		WithFollowSymlinks(4),
+		WithDenyDotfiles(true),
+		WithDenyGlobs("/private/*"),
+	)
+
+	var tests = []struct {
+		desc string
+		name string
+		err  error
+	}{
+		{
+			desc: "allowed target",
+			name: "allowed",
+		},
+		{
+			desc: "dotfile target",
+			name: "dotfile",
+			err:  os.ErrNotExist,
+		},
+		{
+			desc: "chain to dotfile target",
+			name: "chain",
+			err:  os.ErrNotExist,
+		},
+		{
+			desc: "denied target",
+			name: "denied",
+			err:  os.ErrPermission,
+		},
+		{
+			desc: "denied target through parent",
+			name: "dir/private/key",
+			err:  os.ErrPermission,
+		},
+	}
+
+	for i, tt := range tests {
+		for _, fn := range []func(string) error{
+			func(name string) error {
+				_, err := fs.ReadFile(name)
+				return err
+			},
+			func(name string) error {
+				_, err := fs.Stat(name)
+				return err
+			},
+			func(name string) error {
+				f, err := fs.Open(name)
+				if err == nil {
+					_ = f.Close()
+				}
+				return err
+			},
+		} {
+			if err := fn(tt.name); !errors.Is(err, tt.err) {
+				t.Fatalf("[%02d] test %q, unexpected error: %v != %v",
+					i, tt.desc, tt.err, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package sshttp
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// DialTunnel opens a TCP connection to remoteAddr from the remote host, such
+// as a HTTP service bound to localhost:8080 on that host, tunneled through
+// the SSH connection to host.  The SSH connection is dialed or reused from
+// the connection pool in the same manner as RoundTrip.
+//
+// DialTunnel complements RoundTrip's access to remote files, and may be used
+// to reach services on the remote host using a http.Transport:
+//
+//	tr := &http.Transport{
+//		DialContext: func(_ context.Context, _, addr string) (net.Conn, error) {
+//			return rt.DialTunnel("example.com:22", addr)
+//		},
+//	}
+//
+// The SSH connection is not closed for being idle while tunneled
+// connections are open.
+func (rt *RoundTripper) DialTunnel(host string, remoteAddr string) (net.Conn, error) {
+	p, err := rt.pair(host)
+	if err != nil {
+		return nil, err
+	}
+
+	// Track the tunnel as in flight until it is closed
+	p.inFlight.Add(1)
+	c, err := p.sshc.DialContext(context.Background(), "tcp", remoteAddr)
+	if err != nil {
+		p.inFlight.Add(-1)
+		return nil, err
+	}
+
+	return &tunnelConn{
+		Conn: c,
+		done: func() { p.inFlight.Add(-1) },
+	}, nil
+}
+
+// tunnelConn is a net.Conn tunneled through a SSH connection, which invokes
+// done once when it is closed.
+type tunnelConn struct {
+	net.Conn
+	once sync.Once
+	done func()
+}
+
+// Close implements net.Conn.
+func (c *tunnelConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.done)
+	return err
+}
@@ -0,0 +1,79 @@
+package sshttp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRoundTripperDialTunnel(t *testing.T) {
+	s := newTestServer(t)
+
+	// A HTTP service which is reached through the SSH server
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "hello tunnel")
+	}))
+	defer srv.Close()
+
+	rt := newTestRoundTripper(t)
+	tr := &http.Transport{
+		DialContext: func(_ context.Context, _, addr string) (net.Conn, error) {
+			return rt.DialTunnel(s.addr, addr)
+		},
+		DisableKeepAlives: true,
+	}
+	defer tr.CloseIdleConnections()
+
+	res, err := (&http.Client{Transport: tr}).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("failed to perform request: %v", err)
+	}
+	b, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+
+	if want, got := http.StatusOK, res.StatusCode; want != got {
+		t.Fatalf("unexpected status code: %v != %v", want, got)
+	}
+	if want, got := "hello tunnel", string(b); want != got {
+		t.Fatalf("unexpected body: %q != %q", want, got)
+	}
+
+	// Tunnels share the pooled SSH connection, and are only in flight
+	// while they are open
+	c, err := rt.DialTunnel(s.addr, srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial tunnel: %v", err)
+	}
+	if want, got := int64(1), rt.Stats().InFlight[s.addr]; want != got {
+		t.Fatalf("unexpected in flight requests: %v != %v", want, got)
+	}
+	_ = c.Close()
+	_ = c.Close()
+	if want, got := int64(0), rt.Stats().InFlight[s.addr]; want != got {
+		t.Fatalf("unexpected in flight requests after close: %v != %v", want, got)
+	}
+	if want, got := int32(1), s.dials.Load(); want != got {
+		t.Fatalf("unexpected number of dials: %v != %v", want, got)
+	}
+
+	// Addresses which cannot be reached from the remote host are errors
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	down := l.Addr().String()
+	_ = l.Close()
+
+	if _, err := rt.DialTunnel(s.addr, down); err == nil {
+		t.Fatal("expected an error for an unreachable address")
+	}
+	if want, got := int64(0), rt.Stats().InFlight[s.addr]; want != got {
+		t.Fatalf("unexpected in flight requests after failure: %v != %v", want, got)
+	}
+}
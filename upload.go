@@ -0,0 +1,225 @@
+package sshttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+)
+
+// WithUploads configures a RoundTripper to accept file uploads from HTML
+// forms, using POST requests with a multipart/form-data body.  Each file in
+// the body is written to the remote directory named by the request's path,
+// using the base name of the uploaded file.  Other form fields are ignored.
+// By default, uploads are not accepted.
+//
+// If overwrite is false, uploading a file which already exists fails with
+// 409 Conflict.  Otherwise, existing files are replaced.
+//
+// If the request carries an If-Match header, it is evaluated against each
+// uploaded file before the file is written, in the same manner as for PATCH
+// requests, and the upload stops with 412 Precondition Failed if it does
+// not match.  For example, "If-Match: *" only permits existing files to be
+// replaced.
+//
+// Path rules and the existence of the target directory are checked before
+// any of the request body is read, so that, as with PATCH, clients which send
+// "Expect: 100-continue" need not send the body of a rejected upload.  Each
+// file's own path rules and If-Match precondition can only be checked once
+// its part headers have been read, but are checked before its contents are.
+// The size of uploaded files is not limited.
+//
+// If the request carries a X-Last-Modified header in HTTP date format, the
+// access and modification times of each file are set to its value once the
+// file is written, in the same manner as for PATCH requests.
+//
+// The permissions of created files may be set using WithDefaultMode, or for
+// an individual request using the X-File-Mode header.  An invalid
+// X-File-Mode or X-Last-Modified header is refused with 400 Bad Request.
+//
+// Files are streamed to the remote host as the request body is read, so
+// large uploads are not buffered in memory.  If an upload fails, files which
+// were already written are not removed.  On success, the response body is a
+// JSON array describing the name and size of each file written.
+func WithUploads(overwrite bool) Option {
+	return func(o *options) {
+		o.uploads = true
+		o.uploadOverwrite = overwrite
+	}
+}
+
+// An uploadEntry is an entry in the JSON summary of an upload.
+type uploadEntry struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// isUpload reports whether r carries a multipart/form-data body.
+func isUpload(r *http.Request) bool {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mt == "multipart/form-data"
+}
+
+// upload writes each file in the multipart/form-data body of r to the
+// remote directory named by r.URL.Path, and responds with a JSON summary of
+// the files written.
+func (rt *RoundTripper) upload(p *clientPair, r *http.Request) (*http.Response, error) {
+	// Ensure this path may be served, hiding or forbidding it if needed
+	dir := path.Clean("/" + r.URL.Path)
+	if err := rt.opts.checkPath(dir); err != nil {
+		return rt.errorResponse(r, err)
+	}
+
+	// Validate any requested file mode and modification time before
+	// writing
+	mode := rt.opts.defaultMode
+	if v := r.Header.Get("X-File-Mode"); v != "" {
+		m, err := parseMode(v)
+		if err != nil {
+			return rt.httpResponse(http.StatusBadRequest, nil, nil), nil
+		}
+		mode = &m
+	}
+	modTime, ok := lastModified(r)
+	if !ok {
+		return rt.httpResponse(http.StatusBadRequest, nil, nil), nil
+	}
+
+	// Files may only be uploaded into an existing directory
+	var stat os.FileInfo
+	err := rt.opts.retry(r.Context(), func() (err error) {
+		stat, err = p.sftpc.Stat(dir)
+		return err
+	})
+	if err != nil {
+		return rt.errorResponse(r, err)
+	}
+	if !stat.IsDir() {
+		return rt.httpResponse(http.StatusConflict, nil, nil), nil
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return rt.httpResponse(http.StatusBadRequest, nil, nil), nil
+	}
+
+	entries := make([]uploadEntry, 0)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return rt.httpResponse(http.StatusBadRequest, nil, nil), nil
+		}
+
+		// Skip form fields which are not files
+		if part.FormName() == "" || part.FileName() == "" {
+			_ = part.Close()
+			continue
+		}
+
+		// Only the base name of the file is used, so that uploads cannot
+		// escape the target directory
+		name := path.Base("/" + part.FileName())
+		if name == "/" || name == "." || name == ".." {
+			_ = part.Close()
+			return rt.httpResponse(http.StatusBadRequest, nil, nil), nil
+		}
+
+		fpath := path.Join(dir, name)
+		if err := rt.opts.checkPath(fpath); err != nil {
+			_ = part.Close()
+			return rt.errorResponse(r, err)
+		}
+
+		ok, err := rt.ifMatchFile(p, r, fpath)
+		if err != nil {
+			_ = part.Close()
+			return rt.errorResponse(r, err)
+		}
+		if !ok {
+			_ = part.Close()
+			return rt.httpResponse(http.StatusPreconditionFailed, nil, nil), nil
+		}
+
+		n, ok, err := rt.uploadFile(p, fpath, mode, part)
+		_ = part.Close()
+		if err != nil {
+			return rt.errorResponse(r, err)
+		}
+		if !ok {
+			return rt.httpResponse(http.StatusConflict, nil, nil), nil
+		}
+
+		if !modTime.IsZero() {
+			if err := p.sftpc.Chtimes(fpath, modTime, modTime); err != nil {
+				return rt.errorResponse(r, err)
+			}
+		}
+
+		entries = append(entries, uploadEntry{
+			Name: name,
+			Size: n,
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(entries); err != nil {
+		return nil, err
+	}
+
+	h := http.Header{}
+	h.Set("Content-Type", "application/json")
+	h.Set("Content-Length", strconv.Itoa(buf.Len()))
+
+	return rt.httpResponse(http.StatusCreated, io.NopCloser(&buf), h), nil
+}
+
+// uploadFile writes the contents of r to the remote file fpath, and returns
+// the number of bytes written.  If the file already exists and may not be
+// overwritten, uploadFile returns false.  If mode is not nil and the file is
+// created, its permissions are set to mode before it is written.
+func (rt *RoundTripper) uploadFile(p *clientPair, fpath string, mode *os.FileMode, r io.Reader) (int64, bool, error) {
+	// SFTP servers do not consistently report why an exclusive create
+	// failed, and only created files have their mode set, so check for an
+	// existing file first
+	var exists bool
+	if !rt.opts.uploadOverwrite || mode != nil {
+		_, err := p.sftpc.Lstat(fpath)
+		exists = err == nil
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !rt.opts.uploadOverwrite {
+		if exists {
+			return 0, false, nil
+		}
+
+		flags |= os.O_EXCL
+	}
+
+	f, err := p.sftpc.OpenFile(fpath, flags)
+	if err != nil {
+		return 0, false, err
+	}
+	defer p.invalidate(fpath)
+
+	if mode != nil && !exists {
+		if err := f.Chmod(*mode); err != nil {
+			_ = f.Close()
+			return 0, false, err
+		}
+	}
+
+	var sErr stickyError
+	n, err := io.Copy(f, r)
+	sErr.Set(err)
+	sErr.Set(f.Close())
+
+	return n, true, sErr.Get()
+}
@@ -0,0 +1,320 @@
+package sshttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newUploadRequest creates a multipart/form-data request which uploads each
+// of files, keyed by file name, to url.
+func newUploadRequest(t *testing.T, url string, files map[string]string) *http.Request {
+	t.Helper()
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	// Form fields which are not files are ignored
+	_ = mw.WriteField("comment", "ignored")
+	for _, name := range []string{"a.txt", "b.txt"} {
+		body, ok := files[name]
+		if !ok {
+			continue
+		}
+
+		w, err := mw.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatalf("failed to create form file: %v", err)
+		}
+		_, _ = w.Write([]byte(body))
+	}
+	_ = mw.Close()
+
+	r, err := http.NewRequest(http.MethodPost, url, &buf)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+
+	return r
+}
+
+func TestRoundTripperUpload(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "uploads/b.txt", []byte("old"))
+
+	var tests = []struct {
+		desc      string
+		overwrite bool
+		code      int
+		want      []uploadEntry
+		b         string
+	}{
+		{
+			desc: "conflict",
+			code: http.StatusConflict,
+			b:    "old",
+		},
+		{
+			desc:      "overwrite",
+			overwrite: true,
+			code:      http.StatusCreated,
+			want: []uploadEntry{
+				{Name: "a.txt", Size: 5},
+				{Name: "b.txt", Size: 11},
+			},
+			b: "hello world",
+		},
+	}
+
+	for i, tt := range tests {
+		rt := newTestRoundTripper(t, WithUploads(tt.overwrite))
+
+		r := newUploadRequest(t, s.url("uploads"), map[string]string{
+			"a.txt": "hello",
+			"b.txt": "hello world",
+		})
+		res, body := do(t, rt, r)
+
+		if want, got := tt.code, res.StatusCode; want != got {
+			t.Fatalf("[%02d] test %q, unexpected status code: %v != %v",
+				i, tt.desc, want, got)
+		}
+
+		b, err := os.ReadFile(filepath.FromSlash(s.path("uploads/b.txt")))
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to read file: %v",
+				i, tt.desc, err)
+		}
+		if want, got := tt.b, string(b); want != got {
+			t.Fatalf("[%02d] test %q, unexpected contents: %q != %q",
+				i, tt.desc, want, got)
+		}
+
+		if tt.want == nil {
+			continue
+		}
+
+		var entries []uploadEntry
+		if err := json.Unmarshal(body, &entries); err != nil {
+			t.Fatalf("[%02d] test %q, failed to unmarshal summary: %v",
+				i, tt.desc, err)
+		}
+		if want, got := tt.want, entries; !reflect.DeepEqual(want, got) {
+			t.Fatalf("[%02d] test %q, unexpected summary: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestRoundTripperUploadNotDirectory(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "file", nil)
+
+	rt := newTestRoundTripper(t, WithUploads(true))
+	res, _ := do(t, rt, newUploadRequest(t, s.url("file"), map[string]string{
+		"a.txt": "hello",
+	}))
+
+	if want, got := http.StatusConflict, res.StatusCode; want != got {
+		t.Fatalf("unexpected status code: %v != %v", want, got)
+	}
+}
+
+func TestRoundTripperUploadIfMatch(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "uploads/b.txt", []byte("old"))
+
+	var tests = []struct {
+		desc  string
+		files map[string]string
+		code  int
+		b     string
+	}{
+		{
+			desc: "missing file",
+			files: map[string]string{
+				"a.txt": "hello",
+				"b.txt": "hello world",
+			},
+			code: http.StatusPreconditionFailed,
+			b:    "old",
+		},
+		{
+			desc: "existing file",
+			files: map[string]string{
+				"b.txt": "hello world",
+			},
+			code: http.StatusCreated,
+			b:    "hello world",
+		},
+	}
+
+	rt := newTestRoundTripper(t, WithUploads(true))
+
+	for i, tt := range tests {
+		// Only permit existing files to be replaced
+		r := newUploadRequest(t, s.url("uploads"), tt.files)
+		r.Header.Set("If-Match", "*")
+
+		res, _ := do(t, rt, r)
+		if want, got := tt.code, res.StatusCode; want != got {
+			t.Fatalf("[%02d] test %q, unexpected status code: %v != %v",
+				i, tt.desc, want, got)
+		}
+
+		if _, err := os.Stat(filepath.FromSlash(s.path("uploads/a.txt"))); !os.IsNotExist(err) {
+			t.Fatalf("[%02d] test %q, missing file was created: %v", i, tt.desc, err)
+		}
+
+		b, err := os.ReadFile(filepath.FromSlash(s.path("uploads/b.txt")))
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to read file: %v", i, tt.desc, err)
+		}
+		if want, got := tt.b, string(b); want != got {
+			t.Fatalf("[%02d] test %q, unexpected contents: %q != %q",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestRoundTripperUploadMode(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "uploads/b.txt", []byte("old"))
+	if err := os.Chmod(filepath.FromSlash(s.path("uploads/b.txt")), 0604); err != nil {
+		t.Fatalf("failed to set mode: %v", err)
+	}
+
+	var tests = []struct {
+		desc   string
+		opts   []Option
+		header string
+		code   int
+		mode   os.FileMode
+	}{
+		{
+			desc: "default mode",
+			opts: []Option{WithDefaultMode(0600)},
+			code: http.StatusCreated,
+			mode: 0600,
+		},
+		{
+			desc:   "header",
+			header: "0640",
+			code:   http.StatusCreated,
+			mode:   0640,
+		},
+		{
+			desc:   "header overrides default mode",
+			opts:   []Option{WithDefaultMode(0600)},
+			header: "444",
+			code:   http.StatusCreated,
+			mode:   0444,
+		},
+		{
+			desc:   "invalid header",
+			header: "rw-------",
+			code:   http.StatusBadRequest,
+		},
+	}
+
+	for i, tt := range tests {
+		if err := os.RemoveAll(filepath.FromSlash(s.path("uploads/a.txt"))); err != nil {
+			t.Fatalf("[%02d] test %q, failed to remove file: %v", i, tt.desc, err)
+		}
+
+		rt := newTestRoundTripper(t, append(tt.opts, WithUploads(true))...)
+
+		r := newUploadRequest(t, s.url("uploads"), map[string]string{
+			"a.txt": "hello",
+			"b.txt": "hello world",
+		})
+		if tt.header != "" {
+			r.Header.Set("X-File-Mode", tt.header)
+		}
+
+		res, _ := do(t, rt, r)
+		if want, got := tt.code, res.StatusCode; want != got {
+			t.Fatalf("[%02d] test %q, unexpected status code: %v != %v",
+				i, tt.desc, want, got)
+		}
+		if tt.code != http.StatusCreated {
+			continue
+		}
+
+		fi, err := os.Stat(filepath.FromSlash(s.path("uploads/a.txt")))
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to stat file: %v", i, tt.desc, err)
+		}
+		if want, got := tt.mode, fi.Mode().Perm(); want != got {
+			t.Fatalf("[%02d] test %q, unexpected mode: %v != %v",
+				i, tt.desc, want, got)
+		}
+
+		// Replaced files keep their mode
+		fi, err = os.Stat(filepath.FromSlash(s.path("uploads/b.txt")))
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to stat file: %v", i, tt.desc, err)
+		}
+		if want, got := os.FileMode(0604), fi.Mode().Perm(); want != got {
+			t.Fatalf("[%02d] test %q, unexpected mode for replaced file: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+}
+
+func TestRoundTripperUploadLastModified(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "uploads/b.txt", []byte("old"))
+
+	rt := newTestRoundTripper(t, WithUploads(true))
+
+	r := newUploadRequest(t, s.url("uploads"), map[string]string{
+		"a.txt": "hello",
+		"b.txt": "hello world",
+	})
+	modTime := time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)
+	r.Header.Set("X-Last-Modified", modTime.Format(http.TimeFormat))
+
+	res, _ := do(t, rt, r)
+	if want, got := http.StatusCreated, res.StatusCode; want != got {
+		t.Fatalf("unexpected status code: %v != %v", want, got)
+	}
+
+	// Both created and replaced files have their modification time set
+	for _, name := range []string{"a.txt", "b.txt"} {
+		fi, err := os.Stat(filepath.FromSlash(s.path("uploads/" + name)))
+		if err != nil {
+			t.Fatalf("failed to stat %q: %v", name, err)
+		}
+		if want, got := modTime, fi.ModTime(); !want.Equal(got) {
+			t.Fatalf("unexpected modification time for %q: %v != %v",
+				name, want, got)
+		}
+	}
+
+	// Invalid times are refused before any file is written
+	r = newUploadRequest(t, s.url("uploads"), map[string]string{
+		"a.txt": "goodbye",
+	})
+	r.Header.Set("X-Last-Modified", "yesterday")
+
+	res, _ = do(t, rt, r)
+	if want, got := http.StatusBadRequest, res.StatusCode; want != got {
+		t.Fatalf("unexpected status code: %v != %v", want, got)
+	}
+
+	b, err := os.ReadFile(filepath.FromSlash(s.path("uploads/a.txt")))
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if want, got := "hello", string(b); want != got {
+		t.Fatalf("unexpected contents: %q != %q", want, got)
+	}
+}
@@ -0,0 +1,232 @@
+package sshttp
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// WebDAV returns a webdav.FileSystem which provides read and write access to
+// the same remote files as fs, so that a remote directory can be served as a
+// WebDAV share using webdav.Handler:
+//
+//	h := &webdav.Handler{
+//		FileSystem: fs.WebDAV(),
+//		LockSystem: webdav.NewMemLS(),
+//	}
+//
+// WebDAVHandler creates such a handler which also honors If-Match headers.
+// All access is subject to the same restrictions as fs.  Errors for missing
+// or inaccessible files satisfy os.IsNotExist and os.IsPermission, so that
+// webdav.Handler responds with the appropriate status.  Permissions passed
+// to OpenFile and Mkdir are ignored.  New files and directories are created
+// using the mode set by WithDefaultMode, or the remote host's defaults if it
+// is not set.
+func (fs *FileSystem) WebDAV() webdav.FileSystem {
+	return &davFileSystem{fs: fs}
+}
+
+// WebDAVHandler returns a http.Handler which serves the remote files of fs
+// as a WebDAV share, using a webdav.Handler with the specified URL path
+// prefix and lock system.
+//
+// Unlike a webdav.Handler alone, the handler honors the If-Match header for
+// requests which modify a resource, such as PUT, DELETE, and MOVE, and
+// responds with 412 Precondition Failed if the resource does not exist or
+// its entity tag does not match.  Entity tags are the same as those
+// reported by webdav.Handler and RoundTripper.
+func (fs *FileSystem) WebDAVHandler(prefix string, ls webdav.LockSystem) http.Handler {
+	h := &webdav.Handler{
+		Prefix:     prefix,
+		FileSystem: fs.WebDAV(),
+		LockSystem: ls,
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Requests outside of the prefix are rejected by webdav.Handler
+		name, ok := strings.CutPrefix(r.URL.Path, prefix)
+		if !ok || !isDAVWrite(r.Method) || len(r.Header.Values("If-Match")) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		fi, err := fs.Stat(name)
+		switch {
+		case os.IsNotExist(err):
+			fi = nil
+		case os.IsPermission(err):
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		case err != nil:
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if !ifMatch(r, fi) {
+			http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// isDAVWrite reports whether a WebDAV request using method modifies the
+// resource it is made for.
+func isDAVWrite(method string) bool {
+	switch method {
+	case http.MethodPut, http.MethodDelete, "MKCOL", "MOVE", "PROPPATCH":
+		return true
+	}
+
+	return false
+}
+
+var _ webdav.FileSystem = &davFileSystem{}
+
+// davFileSystem adapts a FileSystem to implement webdav.FileSystem.
+type davFileSystem struct {
+	fs *FileSystem
+}
+
+// Mkdir implements webdav.FileSystem.
+func (d *davFileSystem) Mkdir(_ context.Context, name string, _ os.FileMode) error {
+	if err := d.fs.opts.checkPath(name); err != nil {
+		return err
+	}
+
+	fpath, err := d.fs.entry(name)
+	if err != nil {
+		return err
+	}
+	if err := d.fs.pair.sftpc.Mkdir(fpath); err != nil {
+		// SFTP does not report why a directory could not be created, so
+		// check if it already exists
+		if _, serr := d.fs.pair.sftpc.Lstat(fpath); serr == nil {
+			return os.ErrExist
+		}
+
+		return fsError(err)
+	}
+	d.fs.pair.invalidate(fpath)
+
+	if mode := d.fs.opts.defaultMode; mode != nil {
+		if err := d.fs.pair.sftpc.Chmod(fpath, dirMode(*mode)); err != nil {
+			return fsError(err)
+		}
+	}
+
+	return nil
+}
+
+// OpenFile implements webdav.FileSystem.
+func (d *davFileSystem) OpenFile(_ context.Context, name string, flag int, _ os.FileMode) (webdav.File, error) {
+	if err := d.fs.opts.checkPath(name); err != nil {
+		return nil, err
+	}
+
+	fpath, err := d.openTarget(name, flag)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only files which are created have their mode set
+	var mode *os.FileMode
+	if flag&os.O_CREATE != 0 && d.fs.opts.defaultMode != nil {
+		if _, err := d.fs.pair.sftpc.Lstat(fpath); os.IsNotExist(fsError(err)) {
+			mode = d.fs.opts.defaultMode
+		}
+	}
+
+	f, err := d.fs.pair.sftpc.OpenFile(fpath, flag)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+		d.fs.pair.invalidate(fpath)
+	}
+	if mode != nil {
+		if err := f.Chmod(*mode); err != nil {
+			_ = f.Close()
+			return nil, fsError(err)
+		}
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, fsError(err)
+	}
+
+	// Directories require a trailing slash for File.Readdir
+	dir := path.Dir(d.fs.opts.cleanPath(name))
+	if stat.IsDir() {
+		fpath += "/"
+		dir = name
+	}
+
+	return &File{
+		File: f,
+
+		pair: d.fs.pair,
+		name: fpath,
+		dir:  dir,
+		opts: &d.fs.opts,
+	}, nil
+}
+
+// openTarget returns the remote path which should be opened for name using
+// flag.  Symbolic links are resolved for existing files, even if they are
+// opened with os.O_CREATE, so that writes cannot escape the root directory
+// when WithFollowSymlinks is set.  Files which do not exist yet are created
+// in their resolved parent directory, which must also lie under the root.
+func (d *davFileSystem) openTarget(name string, flag int) (string, error) {
+	if flag&os.O_CREATE == 0 || d.fs.opts.symlinkHops <= 0 {
+		return d.fs.target(name)
+	}
+
+	_, err := d.fs.pair.sftpc.Lstat(d.fs.join(name))
+	switch {
+	case err == nil:
+		return d.fs.target(name)
+	case !os.IsNotExist(fsError(err)):
+		return "", fsError(err)
+	}
+
+	return d.fs.entry(name)
+}
+
+// RemoveAll implements webdav.FileSystem.  The root directory may not be
+// removed.
+func (d *davFileSystem) RemoveAll(_ context.Context, name string) error {
+	if d.isRoot(name) {
+		return os.ErrInvalid
+	}
+
+	return d.fs.RemoveAll(name)
+}
+
+// Rename implements webdav.FileSystem.  The root directory may not be
+// renamed, and nothing may be renamed over it.
+func (d *davFileSystem) Rename(_ context.Context, oldName string, newName string) error {
+	if d.isRoot(oldName) || d.isRoot(newName) {
+		return os.ErrInvalid
+	}
+
+	return d.fs.Rename(oldName, newName)
+}
+
+// Stat implements webdav.FileSystem.
+func (d *davFileSystem) Stat(_ context.Context, name string) (os.FileInfo, error) {
+	return d.fs.Stat(name)
+}
+
+// isRoot reports whether name refers to the root directory of the share.
+// webdav.Handler relies on its FileSystem to protect the root from being
+// removed or renamed.
+func (d *davFileSystem) isRoot(name string) bool {
+	return d.fs.opts.cleanPath(name) == "/"
+}
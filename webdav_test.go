@@ -0,0 +1,251 @@
+package sshttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAVFollowSymlinksConfined(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "root/x.txt", []byte("x"))
+	s.writeFile(t, "outside/file", []byte("secret"))
+	if err := os.Symlink(filepath.FromSlash("../outside"), filepath.FromSlash(s.path("root/link"))); err != nil {
+		t.Fatalf("failed to create symbolic link: %v", err)
+	}
+
+	fs := newTestFileSystem(t, s, s.path("root"), WithFollowSymlinks(4))
+	dav := fs.WebDAV()
+	ctx := context.Background()
+
+	var tests = []struct {
+		desc string
+		fn   func() error
+	}{
+		{
+			desc: "mkdir",
+			fn: func() error {
+				return dav.Mkdir(ctx, "/link/escaped", 0755)
+			},
+		},
+		{
+			desc: "create",
+			fn: func() error {
+				f, err := dav.OpenFile(ctx, "/link/new", os.O_CREATE|os.O_WRONLY, 0644)
+				if err == nil {
+					_ = f.Close()
+				}
+				return err
+			},
+		},
+		{
+			desc: "rename into link",
+			fn: func() error {
+				return dav.Rename(ctx, "/x.txt", "/link/moved.txt")
+			},
+		},
+		{
+			desc: "rename out of link",
+			fn: func() error {
+				return dav.Rename(ctx, "/link/file", "/stolen")
+			},
+		},
+		{
+			desc: "remove all",
+			fn: func() error {
+				return dav.RemoveAll(ctx, "/link/file")
+			},
+		},
+		{
+			desc: "remove",
+			fn: func() error {
+				return fs.Remove("/link/file")
+			},
+		},
+		{
+			desc: "lstat",
+			fn: func() error {
+				_, err := fs.Lstat("/link/file")
+				return err
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		if err := tt.fn(); !errors.Is(err, os.ErrPermission) {
+			t.Fatalf("[%02d] test %q, unexpected error: %v != %v",
+				i, tt.desc, os.ErrPermission, err)
+		}
+	}
+
+	// Nothing outside of the root may have been created, moved, or removed
+	entries, err := os.ReadDir(filepath.FromSlash(s.path("outside")))
+	if err != nil {
+		t.Fatalf("failed to read directory: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "file" {
+		t.Fatalf("unexpected entries outside of root: %v", entries)
+	}
+	if _, err := os.Stat(filepath.FromSlash(s.path("root/x.txt"))); err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+
+	// The link itself may be removed, without affecting its target
+	if err := dav.RemoveAll(ctx, "/link"); err != nil {
+		t.Fatalf("failed to remove link: %v", err)
+	}
+	if _, err := os.Lstat(filepath.FromSlash(s.path("root/link"))); !os.IsNotExist(err) {
+		t.Fatalf("link was not removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.FromSlash(s.path("outside/file"))); err != nil {
+		t.Fatalf("link target was removed: %v", err)
+	}
+}
+
+func TestWebDAVHandlerIfMatch(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "file", []byte("hello"))
+
+	fs := newTestFileSystem(t, s, s.root)
+	srv := httptest.NewServer(fs.WebDAVHandler("/dav", webdav.NewMemLS()))
+	defer srv.Close()
+
+	res, err := http.Head(srv.URL + "/dav/file")
+	if err != nil {
+		t.Fatalf("failed to perform request: %v", err)
+	}
+	_ = res.Body.Close()
+	tag := res.Header.Get("ETag")
+	if tag == "" {
+		t.Fatal("no ETag header in response")
+	}
+
+	var tests = []struct {
+		desc    string
+		method  string
+		name    string
+		ifMatch string
+		code    int
+	}{
+		{
+			desc:    "mismatched PUT",
+			method:  http.MethodPut,
+			name:    "file",
+			ifMatch: `"bogus"`,
+			code:    http.StatusPreconditionFailed,
+		},
+		{
+			desc:    "wildcard PUT to missing file",
+			method:  http.MethodPut,
+			name:    "missing",
+			ifMatch: "*",
+			code:    http.StatusPreconditionFailed,
+		},
+		{
+			desc:    "mismatched DELETE",
+			method:  http.MethodDelete,
+			name:    "file",
+			ifMatch: `"bogus"`,
+			code:    http.StatusPreconditionFailed,
+		},
+		{
+			desc:    "matched PUT",
+			method:  http.MethodPut,
+			name:    "file",
+			ifMatch: tag,
+			code:    http.StatusCreated,
+		},
+		{
+			desc:    "stale DELETE",
+			method:  http.MethodDelete,
+			name:    "file",
+			ifMatch: tag,
+			code:    http.StatusPreconditionFailed,
+		},
+	}
+
+	for i, tt := range tests {
+		r, err := http.NewRequest(tt.method, srv.URL+"/dav/"+tt.name, strings.NewReader("hello world"))
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to create request: %v", i, tt.desc, err)
+		}
+		r.Header.Set("If-Match", tt.ifMatch)
+
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("[%02d] test %q, failed to perform request: %v", i, tt.desc, err)
+		}
+		_ = res.Body.Close()
+
+		if want, got := tt.code, res.StatusCode; want != got {
+			t.Fatalf("[%02d] test %q, unexpected status code: %v != %v",
+				i, tt.desc, want, got)
+		}
+	}
+
+	b, err := os.ReadFile(filepath.FromSlash(s.path("file")))
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if want, got := "hello world", string(b); want != got {
+		t.Fatalf("unexpected contents: %q != %q", want, got)
+	}
+	if _, err := os.Stat(filepath.FromSlash(s.path("missing"))); !os.IsNotExist(err) {
+		t.Fatalf("missing file was created: %v", err)
+	}
+}
+
+func TestWebDAVDefaultMode(t *testing.T) {
+	s := newTestServer(t)
+	s.writeFile(t, "existing", []byte("hello"))
+	if err := os.Chmod(filepath.FromSlash(s.path("existing")), 0604); err != nil {
+		t.Fatalf("failed to set mode: %v", err)
+	}
+
+	fs := newTestFileSystem(t, s, s.root, WithDefaultMode(0640))
+	dav := fs.WebDAV()
+	ctx := context.Background()
+
+	for _, name := range []string{"/new", "/existing"} {
+		f, err := dav.OpenFile(ctx, name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+		if err != nil {
+			t.Fatalf("failed to open %q: %v", name, err)
+		}
+		if _, err := f.Write([]byte("hello world")); err != nil {
+			t.Fatalf("failed to write %q: %v", name, err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatalf("failed to close %q: %v", name, err)
+		}
+	}
+	if err := dav.Mkdir(ctx, "/dir", 0777); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	var tests = []struct {
+		name string
+		mode os.FileMode
+	}{
+		{name: "new", mode: 0640},
+		{name: "existing", mode: 0604},
+		{name: "dir", mode: 0750},
+	}
+
+	for i, tt := range tests {
+		fi, err := os.Stat(filepath.FromSlash(s.path(tt.name)))
+		if err != nil {
+			t.Fatalf("[%02d] failed to stat %q: %v", i, tt.name, err)
+		}
+		if want, got := tt.mode, fi.Mode().Perm(); want != got {
+			t.Fatalf("[%02d] unexpected mode for %q: %v != %v",
+				i, tt.name, want, got)
+		}
+	}
+}